@@ -3,15 +3,15 @@ package dbinterface
 import (
 	"context"
 	"fmt"
-	"log"
-	"os"
 	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 //User is used to parse data from DB
@@ -19,6 +19,34 @@ type User struct {
 	Username string
 	Password string
 	UserRole string
+	Email    string   `bson:"email,omitempty" json:"email,omitempty"`
+	APIKeys  []APIKey `bson:"apiKeys,omitempty" json:"apiKeys,omitempty"`
+}
+
+//APIKey is a long lived key a user can hand to scripts. Only the hash of
+//the key is stored, the scopes limit which routes the key can be used on.
+type APIKey struct {
+	ID        string    `bson:"id" json:"id"`
+	Name      string    `bson:"name" json:"name"`
+	Hash      string    `bson:"hash" json:"hash"`
+	Scopes    []string  `bson:"scopes" json:"scopes"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+//The roles a user can have, they are stored in User.UserRole
+const (
+	RoleAdmin  = "admin"
+	RoleDM     = "dm"
+	RolePlayer = "player"
+)
+
+//ValidRole reports if role is one of the known user roles
+func ValidRole(role string) bool {
+	switch strings.ToLower(role) {
+	case RoleAdmin, RoleDM, RolePlayer:
+		return true
+	}
+	return false
 }
 
 //Stats is a subclass of character
@@ -233,10 +261,13 @@ type Character struct {
 	SpellList                      SpellList                      `json:"spellList"`
 	ClassAttributes                []string                       `json:"classAttributes"`
 	DMComments                     string                         `json:"DMComments"`
+	Owner                          string                         `json:"owner"`
 }
 
-//Campaign is used to handle operation on campain collection
+//Campaign is used to handle operation on campain collection. The ID is what
+//identifies a campaign, names only have to be unique per DM.
 type Campaign struct {
+	ID         string `bson:"-" json:"id"`
 	Name       string
 	DM         string
 	Players    []string
@@ -244,69 +275,219 @@ type Campaign struct {
 	Image      string
 }
 
+//campaignDoc is a campaign as it is stored in Mongo, where the ID is the
+//_id of the document
+type campaignDoc struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	Campaign `bson:",inline"`
+}
+
+//HasPlayer reports if username plays in the campaign
+func (c Campaign) HasPlayer(username string) bool {
+	return checkForUser(username, c.Players)
+}
+
+func (doc campaignDoc) campaign() Campaign {
+	doc.Campaign.ID = doc.ID.Hex()
+	return doc.Campaign
+}
+
+//RefreshToken is the server side record of a refresh token. Only the hash
+//of the token given to the client is stored.
+type RefreshToken struct {
+	Hash      string    `bson:"_id" json:"hash"`
+	Username  string    `bson:"username" json:"username"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+}
+
+//PasswordReset is a one time token that lets a user set a new password
+//without the old one. Only the hash of the token is stored.
+type PasswordReset struct {
+	Hash      string    `bson:"_id" json:"hash"`
+	Username  string    `bson:"username" json:"username"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+}
+
+//Invite lets someone register without an account. Only the hash of the
+//code is stored. When Campaign, the ID of a campaign, is set the new user
+//joins it as a player.
+type Invite struct {
+	Hash      string    `bson:"_id" json:"hash"`
+	CreatedBy string    `bson:"createdBy" json:"createdBy"`
+	UserRole  string    `bson:"userRole" json:"userRole"`
+	Campaign  string    `bson:"campaign" json:"campaign"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+}
+
 //DBInterface handles connections to the MongoDB database
 type DBInterface struct {
-	client     *mongo.Client
-	users      *mongo.Collection
-	campains   *mongo.Collection
-	characters *mongo.Collection
+	client         *mongo.Client
+	users          *mongo.Collection
+	campains       *mongo.Collection
+	characters     *mongo.Collection
+	refreshTokens  *mongo.Collection
+	invites        *mongo.Collection
+	passwordResets *mongo.Collection
+	timeout        time.Duration
 }
 
-//Init creates the DB interface
-func (db *DBInterface) Init() {
-	clientOptions := options.Client().ApplyURI("mongodb://typelias.se:27017").SetAuth(options.Credential{
-		AuthSource: "DnDDB", Username: os.Getenv("MongoUser"), Password: os.Getenv("MongoPassword"),
-	})
+//Init connects to MongoDB and verifies the connection with a ping
+func (db *DBInterface) Init(cfg MongoConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	clientOptions := options.Client().ApplyURI(cfg.URI).
+		SetConnectTimeout(time.Duration(cfg.ConnectTimeout)).
+		SetServerSelectionTimeout(time.Duration(cfg.ServerSelectionTimeout)).
+		SetMaxPoolSize(cfg.MaxPoolSize).
+		SetMinPoolSize(cfg.MinPoolSize)
+
+	if cfg.Username != "" {
+		authSource := cfg.AuthSource
+		if authSource == "" {
+			authSource = cfg.Database
+		}
+		clientOptions.SetAuth(options.Credential{
+			AuthSource: authSource, Username: cfg.Username, Password: cfg.Password,
+		})
+	}
+
+	if cfg.TLS {
+		tlsConfig, err := cfg.tlsConfig()
+		if err != nil {
+			return err
+		}
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
 
 	client, err := mongo.Connect(context.TODO(), clientOptions)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("could not connect to mongo at %s: %v", cfg.URI, err)
+	}
+
+	pingTimeout := time.Duration(cfg.ServerSelectionTimeout)
+	if pingTimeout == 0 {
+		pingTimeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		client.Disconnect(context.Background())
+		return fmt.Errorf("could not reach mongo at %s: %v", cfg.URI, err)
 	}
 	db.client = client
 
-	db.users = client.Database("DnDDB").Collection("users")
-	db.campains = client.Database("DnDDB").Collection("campains")
-	db.characters = client.Database("DnDDB").Collection("characters")
-}
+	database := client.Database(cfg.Database)
+	db.users = database.Collection(cfg.UsersCollection)
+	db.campains = database.Collection(cfg.CampaignsCollection)
+	db.characters = database.Collection(cfg.CharactersCollection)
+	db.refreshTokens = database.Collection(cfg.RefreshTokensCollection)
+	db.invites = database.Collection(cfg.InvitesCollection)
+	db.passwordResets = database.Collection(cfg.PasswordResetsCollection)
+	db.timeout = time.Duration(cfg.OperationTimeout)
+
+	// Let Mongo remove expired tokens and invites by itself
+	for _, collection := range []*mongo.Collection{db.refreshTokens, db.invites, db.passwordResets} {
+		_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.M{"expiresAt": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		})
+		if err != nil {
+			fmt.Println("Could not create expiry index on", collection.Name()+":", err)
+		}
+	}
 
-// AddCharacter adds Character to the database and adds it to a campaign
-func (db *DBInterface) AddCharacter(campaignName string, character Character) bool {
-	insRes, err := db.characters.InsertOne(context.TODO(), character)
+	_, err = db.users.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.M{"apiKeys.id": 1},
+	})
 	if err != nil {
-		fmt.Println(err)
-		return false
+		fmt.Println("Could not create api key index:", err)
 	}
-	fmt.Println("Inserted a Character: ", insRes.InsertedID)
 
-	filter := bson.D{{"name", campaignName}}
+	// Campaigns already have an _id from Mongo, only the lookups by name
+	// need an index
+	_, err = db.campains.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "name", Value: 1}, {Key: "dm", Value: 1}},
+	})
+	if err != nil {
+		fmt.Println("Could not create campaign name index:", err)
+	}
+	return nil
+}
+
+//Close disconnects from MongoDB
+func (db *DBInterface) Close() error {
+	return db.client.Disconnect(context.Background())
+}
+
+func (db *DBInterface) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, db.timeout)
+}
 
-	var camp Campaign
+// AddCharacter adds Character to the database and adds it to a campaign,
+// the ID of the new character is returned
+func (db *DBInterface) AddCharacter(ctx context.Context, campaignID string, character Character) (string, error) {
+	campObjID, err := parseID(campaignID)
+	if err != nil {
+		return "", err
+	}
 
-	db.campains.FindOne(context.TODO(), filter).Decode(&camp)
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	id := strings.Split(fmt.Sprintf("%v", insRes.InsertedID), "\"")[1]
+	count, err := db.campains.CountDocuments(ctx, bson.M{"_id": campObjID})
+	if err != nil {
+		fmt.Println(err)
+		return "", storageError(ctx, err)
+	}
+	if count == 0 {
+		return "", ErrNotFound
+	}
 
-	camp.Characters = append(camp.Characters, id)
+	insRes, err := db.characters.InsertOne(ctx, character)
+	if err != nil {
+		fmt.Println(err)
+		return "", storageError(ctx, err)
+	}
+	fmt.Println("Inserted a Character: ", insRes.InsertedID)
 
-	db.campains.ReplaceOne(context.TODO(), bson.M{"name": campaignName}, camp)
-	return true
+	id := insRes.InsertedID.(primitive.ObjectID).Hex()
 
+	_, err = db.campains.UpdateOne(ctx, bson.M{"_id": campObjID}, bson.M{"$push": bson.M{"characters": id}})
+	if err != nil {
+		fmt.Println(err)
+		return "", storageError(ctx, err)
+	}
+	return id, nil
 }
 
 //GetCharacterByID gets a character based on an ID
-func (db *DBInterface) GetCharacterByID(id string) (Character, bool) {
+func (db *DBInterface) GetCharacterByID(ctx context.Context, id string) (Character, error) {
+	objID, err := parseID(id)
+	if err != nil {
+		return Character{}, err
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	objID, _ := primitive.ObjectIDFromHex(id)
 	filter := bson.M{"_id": objID}
 	var res Character
-	err := db.characters.FindOne(context.TODO(), filter).Decode(&res)
+	err = db.characters.FindOne(ctx, filter).Decode(&res)
 
+	if err == mongo.ErrNoDocuments {
+		return Character{}, ErrNotFound
+	}
 	if err != nil {
 		fmt.Println(err)
-		return Character{}, false
+		return Character{}, storageError(ctx, err)
 	}
 
-	return res, true
+	return res, nil
 }
 
 //MultiCharacterGetReturn is a helper struct for returning mulitple characters
@@ -315,153 +496,352 @@ type MultiCharacterGetReturn struct {
 	Character Character `json:"character"`
 }
 
-//GetMultiCharacter gets alla the character by string array of character id:s
-func (db *DBInterface) GetMultiCharacter(ids []string) []MultiCharacterGetReturn {
+//GetMultiCharacter gets alla the character by string array of character id:s,
+//characters that do not exist are left out
+func (db *DBInterface) GetMultiCharacter(ctx context.Context, ids []string) ([]MultiCharacterGetReturn, error) {
 	var ret []MultiCharacterGetReturn
 	for _, v := range ids {
-		ch, found := db.GetCharacterByID(v)
-		if found {
-			ret = append(ret, MultiCharacterGetReturn{ID: v, Character: ch})
-
+		ch, err := db.GetCharacterByID(ctx, v)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
 		}
+		ret = append(ret, MultiCharacterGetReturn{ID: v, Character: ch})
 	}
 
-	return ret
+	return ret, nil
 }
 
 //UpdateCharacter updates a character given an ID
-func (db *DBInterface) UpdateCharacter(id string, ch Character) bool {
-	objID, _ := primitive.ObjectIDFromHex(id)
+func (db *DBInterface) UpdateCharacter(ctx context.Context, id string, ch Character) error {
+	objID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	filter := bson.M{"_id": objID}
-	res, err := db.characters.ReplaceOne(context.TODO(), filter, ch)
+	res, err := db.characters.ReplaceOne(ctx, filter, ch)
 	if err != nil {
 		fmt.Println(err)
-		return false
+		return storageError(ctx, err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
 	}
 
-	fmt.Println(res)
-	return true
+	return nil
 }
 
-//RemoveCharacter removes a character based on ID
-func (db *DBInterface) RemoveCharacter(id string) bool {
-	objID, _ := primitive.ObjectIDFromHex(id)
-	filter := bson.M{"_id": objID}
-	res, err := db.characters.DeleteOne(context.TODO(), filter)
+//PatchCharacter changes only the fields in the patch and returns the
+//character as it is stored afterwards. Fields that are not in the patch keep
+//what is stored, even if they changed since the patch was made. When the
+//fields in expect no longer have its values ErrConflict is returned and
+//nothing is changed.
+func (db *DBInterface) PatchCharacter(ctx context.Context, id string, patch, expect CharacterPatch) (Character, error) {
+	objID, err := parseID(id)
+	if err != nil {
+		return Character{}, err
+	}
+	update, err := patch.update()
+	if err != nil {
+		return Character{}, err
+	}
+	filter, err := expect.filter()
+	if err != nil {
+		return Character{}, err
+	}
+	filter["_id"] = objID
+	if len(update) == 0 {
+		return db.GetCharacterByID(ctx, id)
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var res Character
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = db.characters.FindOneAndUpdate(ctx, filter, update, opts).Decode(&res)
+	if err == mongo.ErrNoDocuments {
+		// Tell a character that changed apart from one that is gone
+		count, err := db.characters.CountDocuments(ctx, bson.M{"_id": objID})
+		if err != nil {
+			fmt.Println(err)
+			return Character{}, storageError(ctx, err)
+		}
+		if count > 0 {
+			return Character{}, ErrConflict
+		}
+		return Character{}, ErrNotFound
+	}
 	if err != nil {
 		fmt.Println(err)
-		return false
+		return Character{}, storageError(ctx, err)
+	}
+	return res, nil
+}
+
+//RemoveCharacter removes a character based on ID and takes it out of its
+//campaign
+func (db *DBInterface) RemoveCharacter(ctx context.Context, id string) error {
+	objID, err := parseID(id)
+	if err != nil {
+		return err
 	}
 
-	fmt.Println(res)
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.M{"_id": objID}
+	res, err := db.characters.DeleteOne(ctx, filter)
+	if err != nil {
+		fmt.Println(err)
+		return storageError(ctx, err)
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
 
-	return true
+	_, err = db.campains.UpdateMany(ctx, bson.M{"characters": id}, bson.M{"$pull": bson.M{"characters": id}})
+	if err != nil {
+		fmt.Println(err)
+		return storageError(ctx, err)
+	}
+	return nil
 }
 
-//AddCampain adds new campains to the database
-func (db *DBInterface) AddCampain(campain Campaign) bool {
-	if !db.findCampain(campain.Name) {
-		insRes, err := db.campains.InsertOne(context.TODO(), campain)
-		if err != nil {
+//GetCharactersByOwner gets all characters owned by a user
+func (db *DBInterface) GetCharactersByOwner(ctx context.Context, owner string) ([]MultiCharacterGetReturn, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	cur, err := db.characters.Find(ctx, bson.M{"owner": owner}, options.Find())
+	if err != nil {
+		fmt.Println(err)
+		return nil, storageError(ctx, err)
+	}
+	defer cur.Close(ctx)
+
+	var ret []MultiCharacterGetReturn
+	for cur.Next(ctx) {
+		var elem struct {
+			ID        primitive.ObjectID `bson:"_id"`
+			Character `bson:",inline"`
+		}
+		if err := cur.Decode(&elem); err != nil {
 			fmt.Println(err)
+			continue
 		}
+		ret = append(ret, MultiCharacterGetReturn{ID: elem.ID.Hex(), Character: elem.Character})
+	}
 
-		fmt.Println("Inserted a campain: ", insRes.InsertedID)
-		return true
+	if err := cur.Err(); err != nil {
+		fmt.Println(err)
+		return nil, storageError(ctx, err)
+	}
+
+	return ret, nil
+}
+
+//AddCampain adds new campains to the database and returns the ID of the new
+//campaign. A DM can not have two campaigns with the same name. New campaigns
+//start without characters, they are added with AddCharacter.
+func (db *DBInterface) AddCampain(ctx context.Context, campain Campaign) (string, error) {
+	campain.Characters = []string{}
+	taken, err := db.campaignNameTaken(ctx, campain, primitive.NilObjectID)
+	if err != nil {
+		return "", err
+	}
+	if taken {
+		return "", ErrAlreadyExists
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
+	insRes, err := db.campains.InsertOne(ctx, campain)
+	if err != nil {
+		fmt.Println(err)
+		return "", storageError(ctx, err)
 	}
 
-	return false
+	fmt.Println("Inserted a campain: ", insRes.InsertedID)
+	return insRes.InsertedID.(primitive.ObjectID).Hex(), nil
 }
 
-//UpdateCampaign is used to update a campaign
-func (db *DBInterface) UpdateCampaign(name string, campaignToUpdate Campaign) bool {
+//UpdateCampaign is used to update a campaign, renaming it to the name of
+//another campaign of the same DM gives ErrConflict
+func (db *DBInterface) UpdateCampaign(ctx context.Context, id string, campaignToUpdate Campaign) error {
+	objID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	taken, err := db.campaignNameTaken(ctx, campaignToUpdate, objID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrConflict
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	filter := bson.D{{"name", name}}
+	filter := bson.M{"_id": objID}
 
-	var oldeVersion Campaign
+	var oldeVersion campaignDoc
 
-	db.campains.FindOne(context.TODO(), filter).Decode(&oldeVersion)
+	err = db.campains.FindOne(ctx, filter).Decode(&oldeVersion)
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	if err != nil {
+		fmt.Println(err)
+		return storageError(ctx, err)
+	}
 
 	campaignToUpdate.Characters = oldeVersion.Characters
 
-	result, err := db.campains.ReplaceOne(context.TODO(), bson.M{"name": name}, campaignToUpdate)
+	result, err := db.campains.ReplaceOne(ctx, filter, campaignToUpdate)
 	if err != nil {
 		fmt.Println(err)
-		return false
+		return storageError(ctx, err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
 	}
-	fmt.Println(result)
 
-	return true
+	return nil
 }
 
-//RemoveCampaign removes a Campaign based on username
-func (db *DBInterface) RemoveCampaign(name string) bool {
-	filter := bson.D{{"name", name}}
+//RemoveCampaign removes a Campaign and all of its characters
+func (db *DBInterface) RemoveCampaign(ctx context.Context, id string) error {
+	objID, err := parseID(id)
+	if err != nil {
+		return err
+	}
 
-	var oldeVersion Campaign
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	db.campains.FindOne(context.TODO(), filter).Decode(&oldeVersion)
+	filter := bson.M{"_id": objID}
 
-	list := oldeVersion.Characters
+	var oldeVersion campaignDoc
 
-	for _, v := range list {
-		db.RemoveCharacter(v)
+	err = db.campains.FindOne(ctx, filter).Decode(&oldeVersion)
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	if err != nil {
+		fmt.Println(err)
+		return storageError(ctx, err)
+	}
+
+	var ids []primitive.ObjectID
+	for _, v := range oldeVersion.Characters {
+		if objID, err := parseID(v); err == nil {
+			ids = append(ids, objID)
+		}
+	}
+
+	if len(ids) > 0 {
+		_, err = db.characters.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			fmt.Println(err)
+			return storageError(ctx, err)
+		}
 	}
 
-	result, err := db.campains.DeleteOne(context.TODO(), bson.M{"name": name})
+	_, err = db.campains.DeleteOne(ctx, filter)
 	if err != nil {
 		fmt.Println(err)
-		return false
+		return storageError(ctx, err)
 	}
-	fmt.Println(result)
-	return true
+	return nil
 }
 
-//GetUserCampaign gets specific user campaigns
-func (db *DBInterface) GetUserCampaign(username string) []Campaign {
+func (db *DBInterface) findCampaigns(ctx context.Context, filter interface{}) ([]Campaign, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	var results []Campaign
-	cur, err := db.campains.Find(context.TODO(), bson.D{{}}, options.Find())
+	cur, err := db.campains.Find(ctx, filter, options.Find())
 	if err != nil {
 		fmt.Println(err)
+		return nil, storageError(ctx, err)
 	}
+	defer cur.Close(ctx)
 
-	for cur.Next(context.TODO()) {
-		var elem Campaign
+	for cur.Next(ctx) {
+		var elem campaignDoc
 		err := cur.Decode(&elem)
 		if err != nil {
 			fmt.Println(err)
+			continue
 		}
-		if checkForUser(username, elem.Players) {
-			results = append(results, elem)
+		results = append(results, elem.campaign())
+	}
 
-		}
+	if err := cur.Err(); err != nil {
+		fmt.Println(err)
+		return nil, storageError(ctx, err)
 	}
 
-	return results
+	return results, nil
 }
 
-//GetDMCampaign gets specific campaigns for a specific DM
-func (db *DBInterface) GetDMCampaign(username string) []Campaign {
-	var results []Campaign
-	cur, err := db.campains.Find(context.TODO(), bson.D{{}}, options.Find())
+func (db *DBInterface) findOneCampaign(ctx context.Context, filter interface{}) (Campaign, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var camp campaignDoc
+	err := db.campains.FindOne(ctx, filter).Decode(&camp)
+	if err == mongo.ErrNoDocuments {
+		return Campaign{}, ErrNotFound
+	}
 	if err != nil {
 		fmt.Println(err)
+		return Campaign{}, storageError(ctx, err)
 	}
 
-	for cur.Next(context.TODO()) {
-		var elem Campaign
-		err := cur.Decode(&elem)
-		if err != nil {
-			fmt.Println(err)
-		}
-		if username == elem.DM {
-			results = append(results, elem)
+	return camp.campaign(), nil
+}
 
-		}
+//GetUserCampaign gets specific user campaigns
+func (db *DBInterface) GetUserCampaign(ctx context.Context, username string) ([]Campaign, error) {
+	return db.findCampaigns(ctx, bson.M{"players": username})
+}
+
+//AddCampaignPlayer adds username to the players of a campaign unless it
+//already plays in it. Other changes to the campaign are kept.
+func (db *DBInterface) AddCampaignPlayer(ctx context.Context, id, username string) error {
+	objID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.campains.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$addToSet": bson.M{"players": username}})
+	if err != nil {
+		fmt.Println(err)
+		return storageError(ctx, err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
 	}
+	return nil
+}
 
-	return results
+//GetDMCampaign gets specific campaigns for a specific DM
+func (db *DBInterface) GetDMCampaign(ctx context.Context, username string) ([]Campaign, error) {
+	return db.findCampaigns(ctx, bson.M{"dm": username})
 }
 
 func checkForUser(username string, list []string) bool {
@@ -476,138 +856,553 @@ func checkForUser(username string, list []string) bool {
 	return false
 }
 
+//removeString returns list without any s, it keeps a nil list nil
+func removeString(list []string, s string) []string {
+	res := list[:0:0]
+	for _, v := range list {
+		if v != s {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
 //GetAllCampains gets alla campains
-func (db *DBInterface) GetAllCampains() []Campaign {
-	var results []Campaign
-	cur, err := db.campains.Find(context.TODO(), bson.D{{}}, options.Find())
+func (db *DBInterface) GetAllCampains(ctx context.Context) ([]Campaign, error) {
+	return db.findCampaigns(ctx, bson.D{})
+}
+
+//GetCampaignByID gets a campaign based on its ID
+func (db *DBInterface) GetCampaignByID(ctx context.Context, id string) (Campaign, error) {
+	objID, err := parseID(id)
+	if err != nil {
+		return Campaign{}, err
+	}
+	return db.findOneCampaign(ctx, bson.M{"_id": objID})
+}
+
+//GetCampaignsByName gets every campaign with a name, different DMs can use
+//the same name
+func (db *DBInterface) GetCampaignsByName(ctx context.Context, name string) ([]Campaign, error) {
+	return db.findCampaigns(ctx, bson.M{"name": name})
+}
+
+//GetCharacterCampaign gets the campaign a character belongs to
+func (db *DBInterface) GetCharacterCampaign(ctx context.Context, id string) (Campaign, error) {
+	return db.findOneCampaign(ctx, bson.M{"characters": id})
+}
+
+//campaignNameTaken reports if the DM of camp has another campaign, not the
+//one with the ID except, with the same name
+func (db *DBInterface) campaignNameTaken(ctx context.Context, camp Campaign, except primitive.ObjectID) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.M{"dm": camp.DM, "name": camp.Name, "_id": bson.M{"$ne": except}}
+	count, err := db.campains.CountDocuments(ctx, filter)
 	if err != nil {
 		fmt.Println(err)
+		return false, storageError(ctx, err)
 	}
 
-	for cur.Next(context.TODO()) {
-		var elem Campaign
-		err := cur.Decode(&elem)
+	return count > 0, nil
+}
+
+func (db *DBInterface) findName(ctx context.Context, name string) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	count, err := db.users.CountDocuments(ctx, bson.M{"username": name})
+	if err != nil {
+		fmt.Println(err)
+		return false, storageError(ctx, err)
+	}
+
+	return count > 0, nil
+}
+
+//DeleteUser deletes a user based on username. The user is taken out of the
+//campaigns they play in, their characters go to the DM of their campaign and
+//their tokens are removed. Campaigns the user runs are left to the caller.
+func (db *DBInterface) DeleteUser(ctx context.Context, name string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.users.DeleteOne(ctx, bson.M{"username": name})
+	if err != nil {
+		fmt.Println(err)
+		return storageError(ctx, err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return db.deleteReferences(ctx, name)
+}
+
+//deleteReferences removes a deleted user from campaigns and characters so a
+//new user with the same name does not get their access. Their characters go
+//to the DM of the campaign they are in.
+func (db *DBInterface) deleteReferences(ctx context.Context, name string) error {
+	characters, err := db.GetCharactersByOwner(ctx, name)
+	if err != nil {
+		return err
+	}
+	for _, ch := range characters {
+		owner := ""
+		campaign, err := db.GetCharacterCampaign(ctx, ch.ID)
+		if err == nil {
+			owner = campaign.DM
+		} else if err != ErrNotFound {
+			return err
+		}
+
+		id, err := parseID(ch.ID)
 		if err != nil {
+			return err
+		}
+		filter := bson.M{"_id": id, "owner": name}
+		if _, err := db.characters.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"owner": owner}}); err != nil {
 			fmt.Println(err)
+			return storageError(ctx, err)
 		}
-		results = append(results, elem)
 	}
 
-	return results
+	if _, err := db.campains.UpdateMany(ctx, bson.M{"players": name}, bson.M{"$pull": bson.M{"players": name}}); err != nil {
+		fmt.Println(err)
+		return storageError(ctx, err)
+	}
+	return db.deleteUserTokens(ctx, name)
 }
 
-//GetCampaignByName gets a campaign based on its name
-func (db *DBInterface) GetCampaignByName(name string) Campaign {
-	filter := bson.D{{"name", name}}
-	var camp Campaign
-	db.campains.FindOne(context.TODO(), filter).Decode(&camp)
+//UpdateUser updates a users information, renaming a user to the name of
+//another user gives ErrConflict. The password is hashed before it is stored,
+//an empty password keeps the current one. A renamed user keeps their
+//campaigns and characters and is signed out.
+func (db *DBInterface) UpdateUser(ctx context.Context, user User, userToUpdate string) error {
+	if user.Password != "" {
+		hash, err := hashPassword(user.Password)
+		if err != nil {
+			return err
+		}
+		user.Password = hash
+	}
+
+	if user.Username != userToUpdate {
+		taken, err := db.findName(ctx, user.Username)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrConflict
+		}
+	}
 
-	return camp
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-}
+	// Only the fields that can be edited are set so API keys are kept
+	set := bson.M{"username": user.Username, "userrole": user.UserRole}
+	if user.Password != "" {
+		set["password"] = user.Password
+	}
+	if user.Email != "" {
+		set["email"] = user.Email
+	}
 
-func (db *DBInterface) findCampain(name string) bool {
-	allCampains := db.GetAllCampains()
+	result, err := db.users.UpdateOne(ctx, bson.M{"username": userToUpdate}, bson.M{"$set": set})
+	if err != nil {
+		fmt.Println(err)
+		return storageError(ctx, err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
 
-	for _, v := range allCampains {
-		if v.Name == name {
-			return true
+	if user.Username != userToUpdate {
+		return db.renameReferences(ctx, userToUpdate, user.Username)
+	}
+	return nil
+}
+
+//renameReferences points campaigns and characters of a renamed user to the
+//new name and removes the tokens issued under the old one
+func (db *DBInterface) renameReferences(ctx context.Context, oldName, newName string) error {
+	updates := []struct {
+		collection *mongo.Collection
+		filter     bson.M
+		update     bson.M
+	}{
+		{db.campains, bson.M{"dm": oldName}, bson.M{"$set": bson.M{"dm": newName}}},
+		{db.campains, bson.M{"players": oldName}, bson.M{"$set": bson.M{"players.$": newName}}},
+		{db.characters, bson.M{"owner": oldName}, bson.M{"$set": bson.M{"owner": newName}}},
+	}
+	for _, u := range updates {
+		if _, err := u.collection.UpdateMany(ctx, u.filter, u.update); err != nil {
+			fmt.Println(err)
+			return storageError(ctx, err)
 		}
 	}
 
-	return false
+	return db.deleteUserTokens(ctx, oldName)
+}
 
+//deleteUserTokens removes the refresh and password reset tokens of a user
+func (db *DBInterface) deleteUserTokens(ctx context.Context, username string) error {
+	for _, collection := range []*mongo.Collection{db.refreshTokens, db.passwordResets} {
+		if _, err := collection.DeleteMany(ctx, bson.M{"username": username}); err != nil {
+			fmt.Println(err)
+			return storageError(ctx, err)
+		}
+	}
+	return nil
 }
 
-func (db *DBInterface) findName(name string) bool {
+//CheckUser checks user credentials and returns the role of the user.
+//Passwords still stored in plaintext are hashed on a successful login.
+func (db *DBInterface) CheckUser(ctx context.Context, username, password string) (string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	allUsers := db.GetAllUsers()
+	filter := bson.M{"username": username}
+	var res User
+	err := db.users.FindOne(ctx, filter).Decode(&res)
 
-	for _, v := range allUsers {
-		if v == name {
-			return true
+	if err == mongo.ErrNoDocuments {
+		noSuchUser(password)
+		return "", ErrInvalidCredentials
+	}
+	if err != nil {
+		fmt.Println(err)
+		return "", storageError(ctx, err)
+	}
+
+	ok, needsRehash := checkPassword(res.Password, password)
+	if !ok {
+		return "", ErrInvalidCredentials
+	}
+
+	if needsRehash {
+		hash, err := rehashPassword(password)
+		if err == nil {
+			_, err = db.users.UpdateOne(ctx,
+				bson.M{"username": username, "password": res.Password},
+				bson.M{"$set": bson.M{"password": hash}})
+		}
+		if err != nil {
+			fmt.Println("Could not rehash password for", username, err)
 		}
 	}
 
-	return false
+	return res.UserRole, nil
 }
 
-//DeleteUser deletes a user based on username
-func (db *DBInterface) DeleteUser(name string) bool {
-	result, err := db.users.DeleteOne(context.TODO(), bson.M{"username": name})
+//AddUser adds user to database with a hashed password
+func (db *DBInterface) AddUser(ctx context.Context, username, password, userRole string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	found, err := db.findName(ctx, username)
+	if err != nil {
+		return err
+	}
+	if found {
+		return ErrAlreadyExists
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	newUser := User{Username: username, Password: hash, UserRole: userRole}
+	insRes, err := db.users.InsertOne(ctx, newUser)
 	if err != nil {
 		fmt.Println(err)
-		return false
+		return storageError(ctx, err)
 	}
-	fmt.Println(result)
-	return true
+
+	fmt.Println("Inserted a user: ", insRes.InsertedID)
+	return nil
 }
 
-//UpdateUser updates a users information
-func (db *DBInterface) UpdateUser(user User, userToUpdate string) bool {
+//GetAllUsers returns a string of all users
+func (db *DBInterface) GetAllUsers(ctx context.Context) ([]string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	result, err := db.users.ReplaceOne(context.TODO(), bson.M{"username": userToUpdate}, user)
+	var results []string
+	cur, err := db.users.Find(ctx, bson.D{}, options.Find())
 	if err != nil {
 		fmt.Println(err)
-		return false
+		return nil, storageError(ctx, err)
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var elem User
+		err := cur.Decode(&elem)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		results = append(results, elem.Username)
+	}
+
+	if err := cur.Err(); err != nil {
+		fmt.Println(err)
+		return nil, storageError(ctx, err)
 	}
-	fmt.Println(result)
 
-	return true
+	return results, nil
+
 }
 
-//CheckUser checks user credentials
-func (db *DBInterface) CheckUser(username, password string) (string, bool) {
-	filter := bson.D{{"username", username}}
+//SetPassword hashes and stores a new password for a user
+func (db *DBInterface) SetPassword(ctx context.Context, username, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.users.UpdateOne(ctx, bson.M{"username": username}, bson.M{"$set": bson.M{"password": hash}})
+	if err != nil {
+		fmt.Println(err)
+		return storageError(ctx, err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+//SetEmail changes the email address of a user
+func (db *DBInterface) SetEmail(ctx context.Context, username, email string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.users.UpdateOne(ctx, bson.M{"username": username}, bson.M{"$set": bson.M{"email": email}})
+	if err != nil {
+		fmt.Println(err)
+		return storageError(ctx, err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+//GetUser gets a user based on username
+func (db *DBInterface) GetUser(ctx context.Context, username string) (User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	var res User
-	err := db.users.FindOne(context.TODO(), filter).Decode(&res)
+	err := db.users.FindOne(ctx, bson.M{"username": username}).Decode(&res)
+	if err == mongo.ErrNoDocuments {
+		return User{}, ErrNotFound
+	}
+	if err != nil {
+		fmt.Println(err)
+		return User{}, storageError(ctx, err)
+	}
+
+	return res, nil
+}
+
+//AddRefreshToken stores a newly issued refresh token
+func (db *DBInterface) AddRefreshToken(ctx context.Context, token RefreshToken) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
+	_, err := db.refreshTokens.InsertOne(ctx, token)
 	if err != nil {
 		fmt.Println(err)
-		return "", false
+		return storageError(ctx, err)
 	}
+	return nil
+}
 
-	if res.Password == password {
-		return res.UserRole, true
+//UseRefreshToken removes a refresh token and returns it, so every token can
+//only be used once. Unknown and expired tokens give ErrNotFound.
+func (db *DBInterface) UseRefreshToken(ctx context.Context, hash string) (RefreshToken, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var res RefreshToken
+	err := db.refreshTokens.FindOneAndDelete(ctx, bson.M{"_id": hash}).Decode(&res)
+	if err == mongo.ErrNoDocuments {
+		return RefreshToken{}, ErrNotFound
+	}
+	if err != nil {
+		fmt.Println(err)
+		return RefreshToken{}, storageError(ctx, err)
+	}
+	if time.Now().After(res.ExpiresAt) {
+		return RefreshToken{}, ErrNotFound
 	}
 
-	return "", false
+	return res, nil
 }
 
-//AddUser adds user to database
-func (db *DBInterface) AddUser(username, password, userRole string) bool {
-	newUser := User{username, password, userRole}
+//RevokeRefreshToken removes a refresh token, unknown tokens are ignored
+func (db *DBInterface) RevokeRefreshToken(ctx context.Context, hash string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	if !db.findName(username) {
-		insRes, err := db.users.InsertOne(context.TODO(), newUser)
-		if err != nil {
-			fmt.Println(err)
-		}
+	_, err := db.refreshTokens.DeleteOne(ctx, bson.M{"_id": hash})
+	if err != nil {
+		fmt.Println(err)
+		return storageError(ctx, err)
+	}
+	return nil
+}
 
-		fmt.Println("Inserted a user: ", insRes.InsertedID)
-		return true
+//RevokeUserRefreshTokens removes every refresh token of a user
+func (db *DBInterface) RevokeUserRefreshTokens(ctx context.Context, username string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.refreshTokens.DeleteMany(ctx, bson.M{"username": username})
+	if err != nil {
+		fmt.Println(err)
+		return storageError(ctx, err)
 	}
+	return nil
+}
 
-	return false
+//AddAPIKey adds an API key to a user
+func (db *DBInterface) AddAPIKey(ctx context.Context, username string, key APIKey) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.users.UpdateOne(ctx, bson.M{"username": username}, bson.M{"$push": bson.M{"apiKeys": key}})
+	if err != nil {
+		fmt.Println(err)
+		return storageError(ctx, err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
-//GetAllUsers returns a string of all users
-func (db *DBInterface) GetAllUsers() []string {
-	var results []string
-	cur, err := db.users.Find(context.TODO(), bson.D{{}}, options.Find())
+//RemoveAPIKey removes an API key from a user
+func (db *DBInterface) RemoveAPIKey(ctx context.Context, username, id string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.M{"username": username, "apiKeys.id": id}
+	result, err := db.users.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"apiKeys": bson.M{"id": id}}})
 	if err != nil {
 		fmt.Println(err)
+		return storageError(ctx, err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
 	}
+	return nil
+}
 
-	for cur.Next(context.TODO()) {
-		var elem User
-		err := cur.Decode(&elem)
-		if err != nil {
-			fmt.Println(err)
-		}
-		results = append(results, elem.Username)
+//FindAPIKey gets the user that owns the API key with the given id
+func (db *DBInterface) FindAPIKey(ctx context.Context, id string) (User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var res User
+	err := db.users.FindOne(ctx, bson.M{"apiKeys.id": id}).Decode(&res)
+	if err == mongo.ErrNoDocuments {
+		return User{}, ErrNotFound
+	}
+	if err != nil {
+		fmt.Println(err)
+		return User{}, storageError(ctx, err)
 	}
 
-	return results
+	return res, nil
+}
+
+//AddInvite stores a new invite
+func (db *DBInterface) AddInvite(ctx context.Context, invite Invite) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.invites.InsertOne(ctx, invite)
+	if err != nil {
+		fmt.Println(err)
+		return storageError(ctx, err)
+	}
+	return nil
+}
+
+//UseInvite removes an invite and returns it, so every code works only once.
+//Unknown and expired invites give ErrNotFound.
+func (db *DBInterface) UseInvite(ctx context.Context, hash string) (Invite, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
+	var res Invite
+	err := db.invites.FindOneAndDelete(ctx, bson.M{"_id": hash}).Decode(&res)
+	if err == mongo.ErrNoDocuments {
+		return Invite{}, ErrNotFound
+	}
+	if err != nil {
+		fmt.Println(err)
+		return Invite{}, storageError(ctx, err)
+	}
+	if time.Now().After(res.ExpiresAt) {
+		return Invite{}, ErrNotFound
+	}
+
+	return res, nil
+}
+
+//AddPasswordReset stores a new password reset token
+func (db *DBInterface) AddPasswordReset(ctx context.Context, reset PasswordReset) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.passwordResets.InsertOne(ctx, reset)
+	if err != nil {
+		fmt.Println(err)
+		return storageError(ctx, err)
+	}
+	return nil
+}
+
+//UsePasswordReset removes a password reset token and returns it. Unknown
+//and expired tokens give ErrNotFound.
+func (db *DBInterface) UsePasswordReset(ctx context.Context, hash string) (PasswordReset, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var res PasswordReset
+	err := db.passwordResets.FindOneAndDelete(ctx, bson.M{"_id": hash}).Decode(&res)
+	if err == mongo.ErrNoDocuments {
+		return PasswordReset{}, ErrNotFound
+	}
+	if err != nil {
+		fmt.Println(err)
+		return PasswordReset{}, storageError(ctx, err)
+	}
+	if time.Now().After(res.ExpiresAt) {
+		return PasswordReset{}, ErrNotFound
+	}
+
+	return res, nil
+}
+
+//RevokeUserPasswordResets removes every password reset token of a user
+func (db *DBInterface) RevokeUserPasswordResets(ctx context.Context, username string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.passwordResets.DeleteMany(ctx, bson.M{"username": username})
+	if err != nil {
+		fmt.Println(err)
+		return storageError(ctx, err)
+	}
+	return nil
 }
@@ -0,0 +1,1156 @@
+package dbinterface
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	usersBucket      = []byte("users")
+	campainsBucket   = []byte("campains")
+	charactersBucket = []byte("characters")
+
+	refreshTokensBucket = []byte("refreshTokens")
+	invitesBucket       = []byte("invites")
+	passwordResetBucket = []byte("passwordResets")
+)
+
+//BoltDB stores all data in a single bbolt file on disk
+type BoltDB struct {
+	db *bolt.DB
+}
+
+var _ Store = (*BoltDB)(nil)
+
+//NewBoltDB opens or creates the database file at path
+func NewBoltDB(path string) (*BoltDB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{usersBucket, campainsBucket, charactersBucket, refreshTokensBucket, invitesBucket, passwordResetBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return migrateCampaigns(tx.Bucket(campainsBucket))
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltDB{db: db}, nil
+}
+
+//Close closes the database file
+func (db *BoltDB) Close() error {
+	return db.db.Close()
+}
+
+//migrateCampaigns gives campaigns stored before campaigns had IDs an ID and
+//moves them from their name to their ID as key
+func migrateCampaigns(b *bolt.Bucket) error {
+	var oldKeys [][]byte
+	var campaigns []Campaign
+	err := b.ForEach(func(k, v []byte) error {
+		var camp Campaign
+		if err := json.Unmarshal(v, &camp); err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		if camp.ID != string(k) {
+			oldKeys = append(oldKeys, append([]byte{}, k...))
+			campaigns = append(campaigns, camp)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, camp := range campaigns {
+		if camp.ID == "" {
+			camp.ID = primitive.NewObjectID().Hex()
+		}
+		if err := b.Delete(oldKeys[i]); err != nil {
+			return err
+		}
+		if err := putJSON(b, camp.ID, camp); err != nil {
+			return err
+		}
+		fmt.Printf("Moved campaign %q to ID %s\n", camp.Name, camp.ID)
+	}
+	return nil
+}
+
+//campaignNameTaken reports if the DM of camp has another campaign with the
+//same name
+func campaignNameTaken(b *bolt.Bucket, camp Campaign) (bool, error) {
+	taken := false
+	err := b.ForEach(func(k, v []byte) error {
+		var other Campaign
+		if err := json.Unmarshal(v, &other); err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		if other.ID != camp.ID && other.DM == camp.DM && other.Name == camp.Name {
+			taken = true
+		}
+		return nil
+	})
+	return taken, err
+}
+
+func getJSON(b *bolt.Bucket, key string, v interface{}) bool {
+	data := b.Get([]byte(key))
+	if data == nil {
+		return false
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return true
+}
+
+//boltError passes our own errors through and wraps everything else
+func boltError(err error) error {
+	switch err {
+	case nil, ErrNotFound, ErrAlreadyExists, ErrConflict, ErrInvalidCredentials, ErrInvalidID:
+		return err
+	}
+	if _, ok := err.(*PatchError); ok {
+		return err
+	}
+	fmt.Println(err)
+	return &StorageError{Err: err}
+}
+
+func putJSON(b *bolt.Bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), data)
+}
+
+// AddCharacter adds Character to the database and adds it to a campaign,
+// the ID of the new character is returned
+func (db *BoltDB) AddCharacter(ctx context.Context, campaignID string, character Character) (string, error) {
+	if err := checkContext(ctx); err != nil {
+		return "", err
+	}
+	if _, err := parseID(campaignID); err != nil {
+		return "", err
+	}
+
+	id := primitive.NewObjectID().Hex()
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		campains := tx.Bucket(campainsBucket)
+		var camp Campaign
+		if !getJSON(campains, campaignID, &camp) {
+			return ErrNotFound
+		}
+
+		if err := putJSON(tx.Bucket(charactersBucket), id, character); err != nil {
+			return err
+		}
+
+		camp.Characters = append(camp.Characters, id)
+		return putJSON(campains, campaignID, camp)
+	})
+	if err != nil {
+		return "", boltError(err)
+	}
+	return id, nil
+}
+
+//GetCharacterByID gets a character based on an ID
+func (db *BoltDB) GetCharacterByID(ctx context.Context, id string) (Character, error) {
+	if err := checkContext(ctx); err != nil {
+		return Character{}, err
+	}
+	if _, err := parseID(id); err != nil {
+		return Character{}, err
+	}
+
+	var res Character
+	err := db.db.View(func(tx *bolt.Tx) error {
+		if !getJSON(tx.Bucket(charactersBucket), id, &res) {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return Character{}, boltError(err)
+	}
+	return res, nil
+}
+
+//GetMultiCharacter gets alla the character by string array of character id:s,
+//characters that do not exist are left out
+func (db *BoltDB) GetMultiCharacter(ctx context.Context, ids []string) ([]MultiCharacterGetReturn, error) {
+	var ret []MultiCharacterGetReturn
+	for _, v := range ids {
+		ch, err := db.GetCharacterByID(ctx, v)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, MultiCharacterGetReturn{ID: v, Character: ch})
+	}
+
+	return ret, nil
+}
+
+//UpdateCharacter updates a character given an ID
+func (db *BoltDB) UpdateCharacter(ctx context.Context, id string, ch Character) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	if _, err := parseID(id); err != nil {
+		return err
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(charactersBucket)
+		if b.Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		return putJSON(b, id, ch)
+	})
+	return boltError(err)
+}
+
+//PatchCharacter changes only the fields in the patch and returns the
+//character as it is stored afterwards. When the fields in expect no longer
+//have its values ErrConflict is returned and nothing is changed.
+func (db *BoltDB) PatchCharacter(ctx context.Context, id string, patch, expect CharacterPatch) (Character, error) {
+	if err := checkContext(ctx); err != nil {
+		return Character{}, err
+	}
+	if _, err := parseID(id); err != nil {
+		return Character{}, err
+	}
+
+	var res Character
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(charactersBucket)
+		var ch Character
+		if !getJSON(b, id, &ch) {
+			return ErrNotFound
+		}
+		ok, err := expect.matches(ch)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrConflict
+		}
+
+		if res, err = patch.Apply(ch); err != nil {
+			return err
+		}
+		return putJSON(b, id, res)
+	})
+	if err != nil {
+		return Character{}, boltError(err)
+	}
+	return res, nil
+}
+
+//RemoveCharacter removes a character based on ID and takes it out of its
+//campaign
+func (db *BoltDB) RemoveCharacter(ctx context.Context, id string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	if _, err := parseID(id); err != nil {
+		return err
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(charactersBucket)
+		if b.Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		if err := b.Delete([]byte(id)); err != nil {
+			return err
+		}
+
+		campains := tx.Bucket(campainsBucket)
+		var changed []Campaign
+		err := campains.ForEach(func(k, v []byte) error {
+			var camp Campaign
+			if err := json.Unmarshal(v, &camp); err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			if checkForUser(id, camp.Characters) {
+				camp.Characters = removeString(camp.Characters, id)
+				changed = append(changed, camp)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, camp := range changed {
+			if err := putJSON(campains, camp.ID, camp); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return boltError(err)
+}
+
+//GetCharactersByOwner gets all characters owned by a user
+func (db *BoltDB) GetCharactersByOwner(ctx context.Context, owner string) ([]MultiCharacterGetReturn, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	var ret []MultiCharacterGetReturn
+	err := db.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(charactersBucket).ForEach(func(k, v []byte) error {
+			var ch Character
+			if err := json.Unmarshal(v, &ch); err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			if ch.Owner == owner {
+				ret = append(ret, MultiCharacterGetReturn{ID: string(k), Character: ch})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, boltError(err)
+	}
+	return ret, nil
+}
+
+//AddCampain adds new campains to the database and returns the ID of the new
+//campaign. A DM can not have two campaigns with the same name. New campaigns
+//start without characters, they are added with AddCharacter.
+func (db *BoltDB) AddCampain(ctx context.Context, campain Campaign) (string, error) {
+	if err := checkContext(ctx); err != nil {
+		return "", err
+	}
+
+	campain.ID = primitive.NewObjectID().Hex()
+	campain.Characters = []string{}
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(campainsBucket)
+		taken, err := campaignNameTaken(b, campain)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrAlreadyExists
+		}
+		return putJSON(b, campain.ID, campain)
+	})
+	if err != nil {
+		return "", boltError(err)
+	}
+	return campain.ID, nil
+}
+
+//UpdateCampaign is used to update a campaign, renaming it to the name of
+//another campaign of the same DM gives ErrConflict
+func (db *BoltDB) UpdateCampaign(ctx context.Context, id string, campaignToUpdate Campaign) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	if _, err := parseID(id); err != nil {
+		return err
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(campainsBucket)
+		var oldeVersion Campaign
+		if !getJSON(b, id, &oldeVersion) {
+			return ErrNotFound
+		}
+
+		campaignToUpdate.ID = id
+		campaignToUpdate.Characters = oldeVersion.Characters
+		taken, err := campaignNameTaken(b, campaignToUpdate)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrConflict
+		}
+		return putJSON(b, id, campaignToUpdate)
+	})
+	return boltError(err)
+}
+
+//AddCampaignPlayer adds username to the players of a campaign unless it
+//already plays in it. Other changes to the campaign are kept.
+func (db *BoltDB) AddCampaignPlayer(ctx context.Context, id, username string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	if _, err := parseID(id); err != nil {
+		return err
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(campainsBucket)
+		var camp Campaign
+		if !getJSON(b, id, &camp) {
+			return ErrNotFound
+		}
+		if checkForUser(username, camp.Players) {
+			return nil
+		}
+		camp.Players = append(camp.Players, username)
+		return putJSON(b, id, camp)
+	})
+	return boltError(err)
+}
+
+//RemoveCampaign removes a Campaign and all of its characters
+func (db *BoltDB) RemoveCampaign(ctx context.Context, id string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	if _, err := parseID(id); err != nil {
+		return err
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(campainsBucket)
+		var oldeVersion Campaign
+		if !getJSON(b, id, &oldeVersion) {
+			return ErrNotFound
+		}
+
+		characters := tx.Bucket(charactersBucket)
+		for _, v := range oldeVersion.Characters {
+			if err := characters.Delete([]byte(v)); err != nil {
+				return err
+			}
+		}
+		return b.Delete([]byte(id))
+	})
+	return boltError(err)
+}
+
+func (db *BoltDB) filterCampaigns(ctx context.Context, keep func(Campaign) bool) ([]Campaign, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	var results []Campaign
+	err := db.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(campainsBucket).ForEach(func(k, v []byte) error {
+			var elem Campaign
+			if err := json.Unmarshal(v, &elem); err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			if keep(elem) {
+				results = append(results, elem)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, boltError(err)
+	}
+	return results, nil
+}
+
+//GetUserCampaign gets specific user campaigns
+func (db *BoltDB) GetUserCampaign(ctx context.Context, username string) ([]Campaign, error) {
+	return db.filterCampaigns(ctx, func(c Campaign) bool {
+		return checkForUser(username, c.Players)
+	})
+}
+
+//GetDMCampaign gets specific campaigns for a specific DM
+func (db *BoltDB) GetDMCampaign(ctx context.Context, username string) ([]Campaign, error) {
+	return db.filterCampaigns(ctx, func(c Campaign) bool {
+		return c.DM == username
+	})
+}
+
+//GetAllCampains gets alla campains
+func (db *BoltDB) GetAllCampains(ctx context.Context) ([]Campaign, error) {
+	return db.filterCampaigns(ctx, func(c Campaign) bool {
+		return true
+	})
+}
+
+//GetCampaignByID gets a campaign based on its ID
+func (db *BoltDB) GetCampaignByID(ctx context.Context, id string) (Campaign, error) {
+	if err := checkContext(ctx); err != nil {
+		return Campaign{}, err
+	}
+	if _, err := parseID(id); err != nil {
+		return Campaign{}, err
+	}
+
+	var camp Campaign
+	err := db.db.View(func(tx *bolt.Tx) error {
+		if !getJSON(tx.Bucket(campainsBucket), id, &camp) {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return Campaign{}, boltError(err)
+	}
+	return camp, nil
+}
+
+//GetCampaignsByName gets every campaign with a name, different DMs can use
+//the same name
+func (db *BoltDB) GetCampaignsByName(ctx context.Context, name string) ([]Campaign, error) {
+	return db.filterCampaigns(ctx, func(c Campaign) bool {
+		return c.Name == name
+	})
+}
+
+//GetCharacterCampaign gets the campaign a character belongs to
+func (db *BoltDB) GetCharacterCampaign(ctx context.Context, id string) (Campaign, error) {
+	campaigns, err := db.filterCampaigns(ctx, func(c Campaign) bool {
+		return checkForUser(id, c.Characters)
+	})
+	if err != nil {
+		return Campaign{}, err
+	}
+	if len(campaigns) == 0 {
+		return Campaign{}, ErrNotFound
+	}
+	return campaigns[0], nil
+}
+
+//DeleteUser deletes a user based on username. The user is taken out of the
+//campaigns they play in, their characters go to the DM of their campaign and
+//their tokens are removed. Campaigns the user runs are left to the caller.
+func (db *BoltDB) DeleteUser(ctx context.Context, name string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		if b.Get([]byte(name)) == nil {
+			return ErrNotFound
+		}
+		if err := b.Delete([]byte(name)); err != nil {
+			return err
+		}
+		return deleteReferences(tx, name)
+	})
+	return boltError(err)
+}
+
+//deleteReferences removes a deleted user from campaigns and characters so a
+//new user with the same name does not get their access. Their characters go
+//to the DM of the campaign they are in.
+func deleteReferences(tx *bolt.Tx, name string) error {
+	dms := map[string]string{}
+	err := updateEach(tx.Bucket(campainsBucket), func(_, data []byte) (interface{}, bool) {
+		var camp Campaign
+		if err := json.Unmarshal(data, &camp); err != nil {
+			return nil, false
+		}
+		for _, id := range camp.Characters {
+			dms[id] = camp.DM
+		}
+		if !checkForUser(name, camp.Players) {
+			return nil, false
+		}
+		camp.Players = removeString(camp.Players, name)
+		return camp, true
+	})
+	if err != nil {
+		return err
+	}
+
+	err = updateEach(tx.Bucket(charactersBucket), func(k, data []byte) (interface{}, bool) {
+		var ch Character
+		if err := json.Unmarshal(data, &ch); err != nil || ch.Owner != name {
+			return nil, false
+		}
+		ch.Owner = dms[string(k)]
+		return ch, true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, bucket := range [][]byte{refreshTokensBucket, passwordResetBucket} {
+		if err := deleteUserTokens(tx.Bucket(bucket), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//UpdateUser updates a users information, renaming a user to the name of
+//another user gives ErrConflict. The password is hashed before it is stored,
+//an empty password keeps the current one.
+func (db *BoltDB) UpdateUser(ctx context.Context, user User, userToUpdate string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	if user.Password != "" {
+		hash, err := hashPassword(user.Password)
+		if err != nil {
+			return err
+		}
+		user.Password = hash
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		var old User
+		if !getJSON(b, userToUpdate, &old) {
+			return ErrNotFound
+		}
+		if user.Password == "" {
+			user.Password = old.Password
+		}
+		if user.Email == "" {
+			user.Email = old.Email
+		}
+		user.APIKeys = old.APIKeys
+		if user.Username != userToUpdate {
+			if b.Get([]byte(user.Username)) != nil {
+				return ErrConflict
+			}
+			if err := b.Delete([]byte(userToUpdate)); err != nil {
+				return err
+			}
+			if err := renameReferences(tx, userToUpdate, user.Username); err != nil {
+				return err
+			}
+		}
+		return putJSON(b, user.Username, user)
+	})
+	return boltError(err)
+}
+
+//renameReferences points campaigns and characters of a renamed user to the
+//new name and removes the tokens issued under the old one
+func renameReferences(tx *bolt.Tx, oldName, newName string) error {
+	err := updateEach(tx.Bucket(campainsBucket), func(_, data []byte) (interface{}, bool) {
+		var camp Campaign
+		if err := json.Unmarshal(data, &camp); err != nil {
+			return nil, false
+		}
+		changed := camp.DM == oldName
+		if changed {
+			camp.DM = newName
+		}
+		for i, player := range camp.Players {
+			if player == oldName {
+				camp.Players[i] = newName
+				changed = true
+			}
+		}
+		return camp, changed
+	})
+	if err != nil {
+		return err
+	}
+
+	err = updateEach(tx.Bucket(charactersBucket), func(_, data []byte) (interface{}, bool) {
+		var ch Character
+		if err := json.Unmarshal(data, &ch); err != nil || ch.Owner != oldName {
+			return nil, false
+		}
+		ch.Owner = newName
+		return ch, true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, bucket := range [][]byte{refreshTokensBucket, passwordResetBucket} {
+		if err := deleteUserTokens(tx.Bucket(bucket), oldName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//deleteUserTokens removes the tokens in b that were issued to username
+func deleteUserTokens(b *bolt.Bucket, username string) error {
+	var remove [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		var token struct {
+			Username string `json:"username"`
+		}
+		if json.Unmarshal(v, &token) == nil && token.Username == username {
+			remove = append(remove, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range remove {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//deleteExpired removes the tokens in b that have expired. Mongo does this
+//with an expiry index, here it is done whenever a new token is stored.
+func deleteExpired(b *bolt.Bucket) error {
+	now := time.Now()
+	var remove [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		var token struct {
+			ExpiresAt time.Time `json:"expiresAt"`
+		}
+		if json.Unmarshal(v, &token) == nil && now.After(token.ExpiresAt) {
+			remove = append(remove, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range remove {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//updateEach calls change with every key and value in b and stores what it
+//returns for the values it reports as changed
+func updateEach(b *bolt.Bucket, change func(k, v []byte) (interface{}, bool)) error {
+	changed := map[string]interface{}{}
+	err := b.ForEach(func(k, v []byte) error {
+		if value, ok := change(k, v); ok {
+			changed[string(k)] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for k, value := range changed {
+		if err := putJSON(b, k, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//CheckUser checks user credentials and returns the role of the user.
+//Passwords still stored in plaintext are hashed on a successful login.
+func (db *BoltDB) CheckUser(ctx context.Context, username, password string) (string, error) {
+	if err := checkContext(ctx); err != nil {
+		return "", err
+	}
+
+	var res User
+	found := false
+	db.db.View(func(tx *bolt.Tx) error {
+		found = getJSON(tx.Bucket(usersBucket), username, &res)
+		return nil
+	})
+
+	if !found {
+		noSuchUser(password)
+		return "", ErrInvalidCredentials
+	}
+
+	ok, needsRehash := checkPassword(res.Password, password)
+	if !ok {
+		return "", ErrInvalidCredentials
+	}
+
+	if needsRehash {
+		hash, err := rehashPassword(password)
+		if err == nil {
+			err = db.db.Update(func(tx *bolt.Tx) error {
+				b := tx.Bucket(usersBucket)
+				var current User
+				if !getJSON(b, username, &current) || current.Password != res.Password {
+					return nil
+				}
+				current.Password = hash
+				return putJSON(b, username, current)
+			})
+		}
+		if err != nil {
+			fmt.Println("Could not rehash password for", username, err)
+		}
+	}
+
+	return res.UserRole, nil
+}
+
+//AddUser adds user to database with a hashed password
+func (db *BoltDB) AddUser(ctx context.Context, username, password, userRole string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	err = db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		if b.Get([]byte(username)) != nil {
+			return ErrAlreadyExists
+		}
+		return putJSON(b, username, User{Username: username, Password: hash, UserRole: userRole})
+	})
+	return boltError(err)
+}
+
+//GetAllUsers returns a string of all users
+func (db *BoltDB) GetAllUsers(ctx context.Context) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	var results []string
+	err := db.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+			results = append(results, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, boltError(err)
+	}
+	return results, nil
+}
+
+//SetPassword hashes and stores a new password for a user
+func (db *BoltDB) SetPassword(ctx context.Context, username, password string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	err = db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		var user User
+		if !getJSON(b, username, &user) {
+			return ErrNotFound
+		}
+		user.Password = hash
+		return putJSON(b, username, user)
+	})
+	return boltError(err)
+}
+
+//SetEmail changes the email address of a user
+func (db *BoltDB) SetEmail(ctx context.Context, username, email string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		var user User
+		if !getJSON(b, username, &user) {
+			return ErrNotFound
+		}
+		user.Email = email
+		return putJSON(b, username, user)
+	})
+	return boltError(err)
+}
+
+//GetUser gets a user based on username
+func (db *BoltDB) GetUser(ctx context.Context, username string) (User, error) {
+	if err := checkContext(ctx); err != nil {
+		return User{}, err
+	}
+
+	var res User
+	err := db.db.View(func(tx *bolt.Tx) error {
+		if !getJSON(tx.Bucket(usersBucket), username, &res) {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return User{}, boltError(err)
+	}
+	return res, nil
+}
+
+//AddRefreshToken stores a newly issued refresh token
+func (db *BoltDB) AddRefreshToken(ctx context.Context, token RefreshToken) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(refreshTokensBucket)
+		if err := deleteExpired(b); err != nil {
+			return err
+		}
+		return putJSON(b, token.Hash, token)
+	})
+	return boltError(err)
+}
+
+//UseRefreshToken removes a refresh token and returns it, so every token can
+//only be used once. Unknown and expired tokens give ErrNotFound.
+func (db *BoltDB) UseRefreshToken(ctx context.Context, hash string) (RefreshToken, error) {
+	if err := checkContext(ctx); err != nil {
+		return RefreshToken{}, err
+	}
+
+	var token RefreshToken
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(refreshTokensBucket)
+		if !getJSON(b, hash, &token) {
+			return ErrNotFound
+		}
+		return b.Delete([]byte(hash))
+	})
+	if err != nil {
+		return RefreshToken{}, boltError(err)
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return RefreshToken{}, ErrNotFound
+	}
+	return token, nil
+}
+
+//RevokeRefreshToken removes a refresh token, unknown tokens are ignored
+func (db *BoltDB) RevokeRefreshToken(ctx context.Context, hash string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(refreshTokensBucket).Delete([]byte(hash))
+	})
+	return boltError(err)
+}
+
+//RevokeUserRefreshTokens removes every refresh token of a user, expired
+//tokens of other users are cleaned up at the same time
+func (db *BoltDB) RevokeUserRefreshTokens(ctx context.Context, username string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(refreshTokensBucket)
+		var remove [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var token RefreshToken
+			if err := json.Unmarshal(v, &token); err != nil || token.Username == username || time.Now().After(token.ExpiresAt) {
+				remove = append(remove, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range remove {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return boltError(err)
+}
+
+//AddAPIKey adds an API key to a user
+func (db *BoltDB) AddAPIKey(ctx context.Context, username string, key APIKey) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		var user User
+		if !getJSON(b, username, &user) {
+			return ErrNotFound
+		}
+		user.APIKeys = append(user.APIKeys, key)
+		return putJSON(b, username, user)
+	})
+	return boltError(err)
+}
+
+//RemoveAPIKey removes an API key from a user
+func (db *BoltDB) RemoveAPIKey(ctx context.Context, username, id string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		var user User
+		if !getJSON(b, username, &user) {
+			return ErrNotFound
+		}
+		keys := []APIKey{}
+		for _, key := range user.APIKeys {
+			if key.ID != id {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) == len(user.APIKeys) {
+			return ErrNotFound
+		}
+		user.APIKeys = keys
+		return putJSON(b, username, user)
+	})
+	return boltError(err)
+}
+
+//FindAPIKey gets the user that owns the API key with the given id
+func (db *BoltDB) FindAPIKey(ctx context.Context, id string) (User, error) {
+	if err := checkContext(ctx); err != nil {
+		return User{}, err
+	}
+
+	var res User
+	found := false
+	err := db.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+			var user User
+			if err := json.Unmarshal(v, &user); err != nil {
+				return err
+			}
+			for _, key := range user.APIKeys {
+				if key.ID == id {
+					res, found = user, true
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return User{}, boltError(err)
+	}
+	if !found {
+		return User{}, ErrNotFound
+	}
+	return res, nil
+}
+
+//AddInvite stores a new invite
+func (db *BoltDB) AddInvite(ctx context.Context, invite Invite) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(invitesBucket)
+		if err := deleteExpired(b); err != nil {
+			return err
+		}
+		return putJSON(b, invite.Hash, invite)
+	})
+	return boltError(err)
+}
+
+//UseInvite removes an invite and returns it, so every code works only once.
+//Unknown and expired invites give ErrNotFound.
+func (db *BoltDB) UseInvite(ctx context.Context, hash string) (Invite, error) {
+	if err := checkContext(ctx); err != nil {
+		return Invite{}, err
+	}
+
+	var invite Invite
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(invitesBucket)
+		if !getJSON(b, hash, &invite) {
+			return ErrNotFound
+		}
+		return b.Delete([]byte(hash))
+	})
+	if err != nil {
+		return Invite{}, boltError(err)
+	}
+	if time.Now().After(invite.ExpiresAt) {
+		return Invite{}, ErrNotFound
+	}
+	return invite, nil
+}
+
+//AddPasswordReset stores a new password reset token
+func (db *BoltDB) AddPasswordReset(ctx context.Context, reset PasswordReset) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(passwordResetBucket)
+		if err := deleteExpired(b); err != nil {
+			return err
+		}
+		return putJSON(b, reset.Hash, reset)
+	})
+	return boltError(err)
+}
+
+//UsePasswordReset removes a password reset token and returns it. Unknown
+//and expired tokens give ErrNotFound.
+func (db *BoltDB) UsePasswordReset(ctx context.Context, hash string) (PasswordReset, error) {
+	if err := checkContext(ctx); err != nil {
+		return PasswordReset{}, err
+	}
+
+	var reset PasswordReset
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(passwordResetBucket)
+		if !getJSON(b, hash, &reset) {
+			return ErrNotFound
+		}
+		return b.Delete([]byte(hash))
+	})
+	if err != nil {
+		return PasswordReset{}, boltError(err)
+	}
+	if time.Now().After(reset.ExpiresAt) {
+		return PasswordReset{}, ErrNotFound
+	}
+	return reset, nil
+}
+
+//RevokeUserPasswordResets removes every password reset token of a user
+func (db *BoltDB) RevokeUserPasswordResets(ctx context.Context, username string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		return deleteUserTokens(tx.Bucket(passwordResetBucket), username)
+	})
+	return boltError(err)
+}
@@ -0,0 +1,149 @@
+package dbinterface
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func testBoltDB(t *testing.T) *BoltDB {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "dndbackend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewBoltDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+	return db
+}
+
+func bucketKeys(t *testing.T, db *BoltDB, bucket []byte) []string {
+	t.Helper()
+	var keys []string
+	err := db.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestBoltDeleteExpired(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		bucket []byte
+		add    func(db *BoltDB, hash string, expires time.Time) error
+	}{
+		{"refresh tokens", refreshTokensBucket, func(db *BoltDB, hash string, expires time.Time) error {
+			return db.AddRefreshToken(ctx, RefreshToken{Hash: hash, Username: "p", ExpiresAt: expires})
+		}},
+		{"invites", invitesBucket, func(db *BoltDB, hash string, expires time.Time) error {
+			return db.AddInvite(ctx, Invite{Hash: hash, CreatedBy: "dm", ExpiresAt: expires})
+		}},
+		{"password resets", passwordResetBucket, func(db *BoltDB, hash string, expires time.Time) error {
+			return db.AddPasswordReset(ctx, PasswordReset{Hash: hash, Username: "p", ExpiresAt: expires})
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := testBoltDB(t)
+			if err := test.add(db, "expired", now.Add(-time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+			if err := test.add(db, "valid", now.Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			if err := test.add(db, "new", now.Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+
+			keys := bucketKeys(t, db, test.bucket)
+			if len(keys) != 2 || keys[0] != "new" || keys[1] != "valid" {
+				t.Errorf("stored keys = %v, want [new valid]", keys)
+			}
+		})
+	}
+}
+
+func TestBoltMigrateCampaigns(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "dndbackend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.db")
+
+	// Before campaigns had IDs they were stored under their name
+	db, err := NewBoltDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.db.Update(func(tx *bolt.Tx) error {
+		old := []byte(`{"Name": "c", "DM": "dm", "Players": ["p"], "Characters": ["5f1a2b3c4d5e6f7a8b9c0d1e"], "Image": "map.png"}`)
+		return tx.Bucket(campainsBucket).Put([]byte("c"), old)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	reopen := func() *BoltDB {
+		t.Helper()
+		db, err := NewBoltDB(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+
+	db = reopen()
+	keys := bucketKeys(t, db, campainsBucket)
+	if len(keys) != 1 || keys[0] == "c" {
+		t.Fatalf("campaign keys = %v, want one ID", keys)
+	}
+	id := keys[0]
+	if _, err := parseID(id); err != nil {
+		t.Fatalf("campaign key %q is not a valid ID", id)
+	}
+
+	camp, err := db.GetCampaignByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Campaign{ID: id, Name: "c", DM: "dm", Players: []string{"p"}, Characters: []string{"5f1a2b3c4d5e6f7a8b9c0d1e"}, Image: "map.png"}
+	if !reflect.DeepEqual(camp, want) {
+		t.Errorf("migrated campaign = %+v, want %+v", camp, want)
+	}
+	if camp, err := db.GetCharacterCampaign(ctx, "5f1a2b3c4d5e6f7a8b9c0d1e"); err != nil || camp.ID != id {
+		t.Errorf("GetCharacterCampaign() = %q, %v, want %q", camp.ID, err, id)
+	}
+	db.Close()
+
+	// Opening a migrated database again keeps the IDs
+	db = reopen()
+	defer db.Close()
+	if keys := bucketKeys(t, db, campainsBucket); len(keys) != 1 || keys[0] != id {
+		t.Errorf("campaign keys after reopening = %v, want [%s]", keys, id)
+	}
+}
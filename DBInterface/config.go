@@ -0,0 +1,118 @@
+package dbinterface
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+//Duration is a time.Duration that is written as "10s" in config files
+type Duration time.Duration
+
+//UnmarshalJSON accepts both duration strings and plain nanoseconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return errors.New("duration must be a string like \"10s\"")
+		}
+		*d = Duration(n)
+		return nil
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+//MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+//MongoConfig holds everything needed to connect to MongoDB
+type MongoConfig struct {
+	URI                  string `json:"uri"`
+	Database             string `json:"database"`
+	AuthSource           string `json:"authSource"`
+	Username             string `json:"username"`
+	Password             string `json:"password"`
+	UsersCollection      string `json:"usersCollection"`
+	CampaignsCollection  string `json:"campaignsCollection"`
+	CharactersCollection string `json:"charactersCollection"`
+
+	RefreshTokensCollection  string `json:"refreshTokensCollection"`
+	InvitesCollection        string `json:"invitesCollection"`
+	PasswordResetsCollection string `json:"passwordResetsCollection"`
+
+	TLS                   bool   `json:"tls"`
+	TLSCAFile             string `json:"tlsCAFile"`
+	TLSInsecureSkipVerify bool   `json:"tlsInsecureSkipVerify"`
+
+	ConnectTimeout         Duration `json:"connectTimeout"`
+	ServerSelectionTimeout Duration `json:"serverSelectionTimeout"`
+	OperationTimeout       Duration `json:"operationTimeout"`
+	MaxPoolSize            uint64   `json:"maxPoolSize"`
+	MinPoolSize            uint64   `json:"minPoolSize"`
+}
+
+//DefaultMongoConfig returns the settings the server has always used
+func DefaultMongoConfig() MongoConfig {
+	return MongoConfig{
+		URI:                      "mongodb://typelias.se:27017",
+		Database:                 "DnDDB",
+		AuthSource:               "DnDDB",
+		UsersCollection:          "users",
+		CampaignsCollection:      "campains",
+		CharactersCollection:     "characters",
+		RefreshTokensCollection:  "refreshTokens",
+		InvitesCollection:        "invites",
+		PasswordResetsCollection: "passwordResets",
+		ConnectTimeout:           Duration(10 * time.Second),
+		ServerSelectionTimeout:   Duration(10 * time.Second),
+		OperationTimeout:         Duration(5 * time.Second),
+		MaxPoolSize:              100,
+	}
+}
+
+//Validate checks that the config can be used to connect
+func (c MongoConfig) Validate() error {
+	switch {
+	case c.URI == "":
+		return errors.New("mongo uri is empty")
+	case c.Database == "":
+		return errors.New("mongo database name is empty")
+	case c.UsersCollection == "" || c.CampaignsCollection == "" || c.CharactersCollection == "" ||
+		c.RefreshTokensCollection == "" || c.InvitesCollection == "" || c.PasswordResetsCollection == "":
+		return errors.New("mongo collection names must not be empty")
+	case c.MinPoolSize > c.MaxPoolSize && c.MaxPoolSize != 0:
+		return fmt.Errorf("mongo minPoolSize (%d) is larger than maxPoolSize (%d)", c.MinPoolSize, c.MaxPoolSize)
+	}
+	return nil
+}
+
+func (c MongoConfig) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: c.TLSInsecureSkipVerify}
+	if c.TLSCAFile == "" {
+		return cfg, nil
+	}
+
+	pem, err := ioutil.ReadFile(c.TLSCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read mongo CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", c.TLSCAFile)
+	}
+	cfg.RootCAs = pool
+	return cfg, nil
+}
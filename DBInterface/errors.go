@@ -0,0 +1,85 @@
+package dbinterface
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	//ErrNotFound is returned when the requested user, campaign or character does not exist
+	ErrNotFound = errors.New("not found")
+	//ErrAlreadyExists is returned when a user or campaign with the same name already exists
+	ErrAlreadyExists = errors.New("already exists")
+	//ErrInvalidID is returned when a character, campaign or other object ID is
+	//not a valid ObjectID
+	ErrInvalidID = errors.New("invalid id")
+	//ErrConflict is returned when an update would clash with another document
+	ErrConflict = errors.New("conflict")
+	//ErrInvalidCredentials is returned by CheckUser when the username or password is wrong
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	//ErrTimeout is returned when a database operation runs past its deadline
+	ErrTimeout = errors.New("database operation timed out")
+)
+
+//StorageError wraps failures of the underlying database
+type StorageError struct {
+	Err error
+}
+
+func (e *StorageError) Error() string {
+	return "storage failure: " + e.Err.Error()
+}
+
+//Unwrap returns the error reported by the database
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
+
+//PatchError is returned when a patch does not fit the document it is
+//applied to. Field is the JSON path of the field that is wrong.
+type PatchError struct {
+	Field  string
+	Reason string
+}
+
+func (e *PatchError) Error() string {
+	return "invalid patch for " + e.Field + ": " + e.Reason
+}
+
+//checkContext returns an error if ctx is already done
+func checkContext(ctx context.Context) error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return ErrTimeout
+	default:
+		return ctx.Err()
+	}
+}
+
+//storageError turns driver errors caused by an expired context into ErrTimeout
+//and wraps everything else in a StorageError
+func storageError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() == context.DeadlineExceeded || errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	if ctx.Err() == context.Canceled {
+		return context.Canceled
+	}
+	return &StorageError{Err: err}
+}
+
+//parseID checks that id is a valid object ID
+func parseID(id string) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return objID, ErrInvalidID
+	}
+	return objID, nil
+}
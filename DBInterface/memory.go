@@ -0,0 +1,877 @@
+package dbinterface
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//MemoryDB keeps all data in memory, it is meant for development and tests
+type MemoryDB struct {
+	mu         sync.RWMutex
+	users      []User
+	campains   []Campaign
+	characters map[string]Character
+
+	refreshTokens map[string]RefreshToken
+	invites       map[string]Invite
+
+	passwordResets map[string]PasswordReset
+}
+
+var _ Store = (*MemoryDB)(nil)
+
+//NewMemoryDB creates an empty in-memory store
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		characters:    make(map[string]Character),
+		refreshTokens: make(map[string]RefreshToken),
+		invites:       make(map[string]Invite),
+
+		passwordResets: make(map[string]PasswordReset),
+	}
+}
+
+func cloneCharacter(ch Character) Character {
+	var res Character
+	data, err := json.Marshal(ch)
+	if err != nil {
+		fmt.Println(err)
+		return ch
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		fmt.Println(err)
+		return ch
+	}
+	return res
+}
+
+func cloneCampaign(camp Campaign) Campaign {
+	if camp.Players != nil {
+		camp.Players = append([]string{}, camp.Players...)
+	}
+	if camp.Characters != nil {
+		camp.Characters = append([]string{}, camp.Characters...)
+	}
+	return camp
+}
+
+func cloneUser(user User) User {
+	if user.APIKeys != nil {
+		keys := make([]APIKey, len(user.APIKeys))
+		for i, key := range user.APIKeys {
+			key.Scopes = append([]string{}, key.Scopes...)
+			keys[i] = key
+		}
+		user.APIKeys = keys
+	}
+	return user
+}
+
+func (db *MemoryDB) campaignIndex(id string) int {
+	for i, v := range db.campains {
+		if v.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+//campaignNameTaken reports if the DM of camp has another campaign with the
+//same name
+func (db *MemoryDB) campaignNameTaken(camp Campaign) bool {
+	for _, v := range db.campains {
+		if v.ID != camp.ID && v.DM == camp.DM && v.Name == camp.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *MemoryDB) userIndex(name string) int {
+	for i, v := range db.users {
+		if v.Username == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// AddCharacter adds Character to the database and adds it to a campaign,
+// the ID of the new character is returned
+func (db *MemoryDB) AddCharacter(ctx context.Context, campaignID string, character Character) (string, error) {
+	if err := checkContext(ctx); err != nil {
+		return "", err
+	}
+	if _, err := parseID(campaignID); err != nil {
+		return "", err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	i := db.campaignIndex(campaignID)
+	if i < 0 {
+		return "", ErrNotFound
+	}
+
+	id := primitive.NewObjectID().Hex()
+	db.characters[id] = cloneCharacter(character)
+
+	db.campains[i].Characters = append(db.campains[i].Characters, id)
+	return id, nil
+}
+
+//GetCharacterByID gets a character based on an ID
+func (db *MemoryDB) GetCharacterByID(ctx context.Context, id string) (Character, error) {
+	if err := checkContext(ctx); err != nil {
+		return Character{}, err
+	}
+	if _, err := parseID(id); err != nil {
+		return Character{}, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	ch, found := db.characters[id]
+	if !found {
+		return Character{}, ErrNotFound
+	}
+	return cloneCharacter(ch), nil
+}
+
+//GetMultiCharacter gets alla the character by string array of character id:s,
+//characters that do not exist are left out
+func (db *MemoryDB) GetMultiCharacter(ctx context.Context, ids []string) ([]MultiCharacterGetReturn, error) {
+	var ret []MultiCharacterGetReturn
+	for _, v := range ids {
+		ch, err := db.GetCharacterByID(ctx, v)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, MultiCharacterGetReturn{ID: v, Character: ch})
+	}
+
+	return ret, nil
+}
+
+//UpdateCharacter updates a character given an ID
+func (db *MemoryDB) UpdateCharacter(ctx context.Context, id string, ch Character) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	if _, err := parseID(id); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, found := db.characters[id]; !found {
+		return ErrNotFound
+	}
+	db.characters[id] = cloneCharacter(ch)
+	return nil
+}
+
+//PatchCharacter changes only the fields in the patch and returns the
+//character as it is stored afterwards. When the fields in expect no longer
+//have its values ErrConflict is returned and nothing is changed.
+func (db *MemoryDB) PatchCharacter(ctx context.Context, id string, patch, expect CharacterPatch) (Character, error) {
+	if err := checkContext(ctx); err != nil {
+		return Character{}, err
+	}
+	if _, err := parseID(id); err != nil {
+		return Character{}, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	ch, found := db.characters[id]
+	if !found {
+		return Character{}, ErrNotFound
+	}
+	ok, err := expect.matches(ch)
+	if err != nil {
+		return Character{}, err
+	}
+	if !ok {
+		return Character{}, ErrConflict
+	}
+	res, err := patch.Apply(ch)
+	if err != nil {
+		return Character{}, err
+	}
+	db.characters[id] = cloneCharacter(res)
+	return res, nil
+}
+
+//RemoveCharacter removes a character based on ID and takes it out of its
+//campaign
+func (db *MemoryDB) RemoveCharacter(ctx context.Context, id string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	if _, err := parseID(id); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, found := db.characters[id]; !found {
+		return ErrNotFound
+	}
+	delete(db.characters, id)
+
+	for i, camp := range db.campains {
+		db.campains[i].Characters = removeString(camp.Characters, id)
+	}
+	return nil
+}
+
+//GetCharactersByOwner gets all characters owned by a user
+func (db *MemoryDB) GetCharactersByOwner(ctx context.Context, owner string) ([]MultiCharacterGetReturn, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var ret []MultiCharacterGetReturn
+	for id, ch := range db.characters {
+		if ch.Owner == owner {
+			ret = append(ret, MultiCharacterGetReturn{ID: id, Character: cloneCharacter(ch)})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret, nil
+}
+
+//AddCampain adds new campains to the database and returns the ID of the new
+//campaign. A DM can not have two campaigns with the same name. New campaigns
+//start without characters, they are added with AddCharacter.
+func (db *MemoryDB) AddCampain(ctx context.Context, campain Campaign) (string, error) {
+	if err := checkContext(ctx); err != nil {
+		return "", err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	campain.ID = primitive.NewObjectID().Hex()
+	campain.Characters = []string{}
+	if db.campaignNameTaken(campain) {
+		return "", ErrAlreadyExists
+	}
+
+	db.campains = append(db.campains, cloneCampaign(campain))
+	return campain.ID, nil
+}
+
+//UpdateCampaign is used to update a campaign, renaming it to the name of
+//another campaign of the same DM gives ErrConflict
+func (db *MemoryDB) UpdateCampaign(ctx context.Context, id string, campaignToUpdate Campaign) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	if _, err := parseID(id); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	i := db.campaignIndex(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	campaignToUpdate.ID = id
+	if db.campaignNameTaken(campaignToUpdate) {
+		return ErrConflict
+	}
+
+	campaignToUpdate = cloneCampaign(campaignToUpdate)
+	campaignToUpdate.Characters = db.campains[i].Characters
+	db.campains[i] = campaignToUpdate
+	return nil
+}
+
+//AddCampaignPlayer adds username to the players of a campaign unless it
+//already plays in it. Other changes to the campaign are kept.
+func (db *MemoryDB) AddCampaignPlayer(ctx context.Context, id, username string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	if _, err := parseID(id); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	i := db.campaignIndex(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	if !checkForUser(username, db.campains[i].Players) {
+		db.campains[i].Players = append(db.campains[i].Players, username)
+	}
+	return nil
+}
+
+//RemoveCampaign removes a Campaign and all of its characters
+func (db *MemoryDB) RemoveCampaign(ctx context.Context, id string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	if _, err := parseID(id); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	i := db.campaignIndex(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	for _, v := range db.campains[i].Characters {
+		delete(db.characters, v)
+	}
+	db.campains = append(db.campains[:i], db.campains[i+1:]...)
+	return nil
+}
+
+func (db *MemoryDB) filterCampaigns(ctx context.Context, keep func(Campaign) bool) ([]Campaign, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var results []Campaign
+	for _, v := range db.campains {
+		if keep(v) {
+			results = append(results, cloneCampaign(v))
+		}
+	}
+	return results, nil
+}
+
+//GetUserCampaign gets specific user campaigns
+func (db *MemoryDB) GetUserCampaign(ctx context.Context, username string) ([]Campaign, error) {
+	return db.filterCampaigns(ctx, func(c Campaign) bool {
+		return checkForUser(username, c.Players)
+	})
+}
+
+//GetDMCampaign gets specific campaigns for a specific DM
+func (db *MemoryDB) GetDMCampaign(ctx context.Context, username string) ([]Campaign, error) {
+	return db.filterCampaigns(ctx, func(c Campaign) bool {
+		return c.DM == username
+	})
+}
+
+//GetAllCampains gets alla campains
+func (db *MemoryDB) GetAllCampains(ctx context.Context) ([]Campaign, error) {
+	return db.filterCampaigns(ctx, func(c Campaign) bool {
+		return true
+	})
+}
+
+//GetCampaignByID gets a campaign based on its ID
+func (db *MemoryDB) GetCampaignByID(ctx context.Context, id string) (Campaign, error) {
+	if err := checkContext(ctx); err != nil {
+		return Campaign{}, err
+	}
+	if _, err := parseID(id); err != nil {
+		return Campaign{}, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if i := db.campaignIndex(id); i >= 0 {
+		return cloneCampaign(db.campains[i]), nil
+	}
+	return Campaign{}, ErrNotFound
+}
+
+//GetCampaignsByName gets every campaign with a name, different DMs can use
+//the same name
+func (db *MemoryDB) GetCampaignsByName(ctx context.Context, name string) ([]Campaign, error) {
+	return db.filterCampaigns(ctx, func(c Campaign) bool {
+		return c.Name == name
+	})
+}
+
+//GetCharacterCampaign gets the campaign a character belongs to
+func (db *MemoryDB) GetCharacterCampaign(ctx context.Context, id string) (Campaign, error) {
+	campaigns, err := db.filterCampaigns(ctx, func(c Campaign) bool {
+		return checkForUser(id, c.Characters)
+	})
+	if err != nil {
+		return Campaign{}, err
+	}
+	if len(campaigns) == 0 {
+		return Campaign{}, ErrNotFound
+	}
+	return campaigns[0], nil
+}
+
+//DeleteUser deletes a user based on username. The user is taken out of the
+//campaigns they play in, their characters go to the DM of their campaign and
+//their tokens are removed. Campaigns the user runs are left to the caller.
+func (db *MemoryDB) DeleteUser(ctx context.Context, name string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	i := db.userIndex(name)
+	if i < 0 {
+		return ErrNotFound
+	}
+	db.users = append(db.users[:i], db.users[i+1:]...)
+
+	dms := map[string]string{}
+	for i, camp := range db.campains {
+		db.campains[i].Players = removeString(camp.Players, name)
+		for _, id := range camp.Characters {
+			dms[id] = camp.DM
+		}
+	}
+	for id, ch := range db.characters {
+		if ch.Owner == name {
+			ch.Owner = dms[id]
+			db.characters[id] = ch
+		}
+	}
+	db.deleteUserTokens(name)
+	return nil
+}
+
+//UpdateUser updates a users information, renaming a user to the name of
+//another user gives ErrConflict. The password is hashed before it is stored,
+//an empty password keeps the current one.
+func (db *MemoryDB) UpdateUser(ctx context.Context, user User, userToUpdate string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	if user.Password != "" {
+		hash, err := hashPassword(user.Password)
+		if err != nil {
+			return err
+		}
+		user.Password = hash
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	i := db.userIndex(userToUpdate)
+	if i < 0 {
+		return ErrNotFound
+	}
+	if user.Username != userToUpdate && db.userIndex(user.Username) >= 0 {
+		return ErrConflict
+	}
+	if user.Password == "" {
+		user.Password = db.users[i].Password
+	}
+	if user.Email == "" {
+		user.Email = db.users[i].Email
+	}
+	user.APIKeys = db.users[i].APIKeys
+	db.users[i] = user
+
+	if user.Username != userToUpdate {
+		db.renameReferences(userToUpdate, user.Username)
+	}
+	return nil
+}
+
+//renameReferences points campaigns and characters of a renamed user to the
+//new name and removes the tokens issued under the old one
+func (db *MemoryDB) renameReferences(oldName, newName string) {
+	for i, camp := range db.campains {
+		if camp.DM == oldName {
+			db.campains[i].DM = newName
+		}
+		for j, player := range camp.Players {
+			if player == oldName {
+				db.campains[i].Players[j] = newName
+			}
+		}
+	}
+	for id, ch := range db.characters {
+		if ch.Owner == oldName {
+			ch.Owner = newName
+			db.characters[id] = ch
+		}
+	}
+	db.deleteUserTokens(oldName)
+}
+
+//deleteUserTokens removes the refresh and password reset tokens of a user
+func (db *MemoryDB) deleteUserTokens(username string) {
+	for hash, token := range db.refreshTokens {
+		if token.Username == username {
+			delete(db.refreshTokens, hash)
+		}
+	}
+	for hash, reset := range db.passwordResets {
+		if reset.Username == username {
+			delete(db.passwordResets, hash)
+		}
+	}
+}
+
+//CheckUser checks user credentials and returns the role of the user.
+//Passwords still stored in plaintext are hashed on a successful login.
+func (db *MemoryDB) CheckUser(ctx context.Context, username, password string) (string, error) {
+	if err := checkContext(ctx); err != nil {
+		return "", err
+	}
+
+	db.mu.RLock()
+	var res User
+	i := db.userIndex(username)
+	if i >= 0 {
+		res = db.users[i]
+	}
+	db.mu.RUnlock()
+
+	if i < 0 {
+		noSuchUser(password)
+		return "", ErrInvalidCredentials
+	}
+
+	ok, needsRehash := checkPassword(res.Password, password)
+	if !ok {
+		return "", ErrInvalidCredentials
+	}
+
+	if needsRehash {
+		if hash, err := rehashPassword(password); err == nil {
+			db.mu.Lock()
+			if i := db.userIndex(username); i >= 0 && db.users[i].Password == res.Password {
+				db.users[i].Password = hash
+			}
+			db.mu.Unlock()
+		}
+	}
+
+	return res.UserRole, nil
+}
+
+//AddUser adds user to database with a hashed password
+func (db *MemoryDB) AddUser(ctx context.Context, username, password, userRole string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.userIndex(username) >= 0 {
+		return ErrAlreadyExists
+	}
+
+	db.users = append(db.users, User{Username: username, Password: hash, UserRole: userRole})
+	return nil
+}
+
+//GetAllUsers returns a string of all users
+func (db *MemoryDB) GetAllUsers(ctx context.Context) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var results []string
+	for _, v := range db.users {
+		results = append(results, v.Username)
+	}
+	return results, nil
+}
+
+//SetPassword hashes and stores a new password for a user
+func (db *MemoryDB) SetPassword(ctx context.Context, username, password string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	i := db.userIndex(username)
+	if i < 0 {
+		return ErrNotFound
+	}
+	db.users[i].Password = hash
+	return nil
+}
+
+//SetEmail changes the email address of a user
+func (db *MemoryDB) SetEmail(ctx context.Context, username, email string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	i := db.userIndex(username)
+	if i < 0 {
+		return ErrNotFound
+	}
+	db.users[i].Email = email
+	return nil
+}
+
+//GetUser gets a user based on username
+func (db *MemoryDB) GetUser(ctx context.Context, username string) (User, error) {
+	if err := checkContext(ctx); err != nil {
+		return User{}, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	i := db.userIndex(username)
+	if i < 0 {
+		return User{}, ErrNotFound
+	}
+	return cloneUser(db.users[i]), nil
+}
+
+//AddRefreshToken stores a newly issued refresh token
+func (db *MemoryDB) AddRefreshToken(ctx context.Context, token RefreshToken) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.refreshTokens[token.Hash] = token
+	return nil
+}
+
+//UseRefreshToken removes a refresh token and returns it, so every token can
+//only be used once. Unknown and expired tokens give ErrNotFound.
+func (db *MemoryDB) UseRefreshToken(ctx context.Context, hash string) (RefreshToken, error) {
+	if err := checkContext(ctx); err != nil {
+		return RefreshToken{}, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	token, found := db.refreshTokens[hash]
+	delete(db.refreshTokens, hash)
+	if !found || time.Now().After(token.ExpiresAt) {
+		return RefreshToken{}, ErrNotFound
+	}
+	return token, nil
+}
+
+//RevokeRefreshToken removes a refresh token, unknown tokens are ignored
+func (db *MemoryDB) RevokeRefreshToken(ctx context.Context, hash string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	delete(db.refreshTokens, hash)
+	return nil
+}
+
+//RevokeUserRefreshTokens removes every refresh token of a user
+func (db *MemoryDB) RevokeUserRefreshTokens(ctx context.Context, username string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for hash, token := range db.refreshTokens {
+		if token.Username == username {
+			delete(db.refreshTokens, hash)
+		}
+	}
+	return nil
+}
+
+//AddAPIKey adds an API key to a user
+func (db *MemoryDB) AddAPIKey(ctx context.Context, username string, key APIKey) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	i := db.userIndex(username)
+	if i < 0 {
+		return ErrNotFound
+	}
+	user := cloneUser(db.users[i])
+	user.APIKeys = append(user.APIKeys, key)
+	db.users[i] = user
+	return nil
+}
+
+//RemoveAPIKey removes an API key from a user
+func (db *MemoryDB) RemoveAPIKey(ctx context.Context, username, id string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	i := db.userIndex(username)
+	if i < 0 {
+		return ErrNotFound
+	}
+	keys := []APIKey{}
+	for _, key := range db.users[i].APIKeys {
+		if key.ID != id {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == len(db.users[i].APIKeys) {
+		return ErrNotFound
+	}
+	db.users[i].APIKeys = keys
+	return nil
+}
+
+//FindAPIKey gets the user that owns the API key with the given id
+func (db *MemoryDB) FindAPIKey(ctx context.Context, id string) (User, error) {
+	if err := checkContext(ctx); err != nil {
+		return User{}, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for _, user := range db.users {
+		for _, key := range user.APIKeys {
+			if key.ID == id {
+				return cloneUser(user), nil
+			}
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+//AddInvite stores a new invite
+func (db *MemoryDB) AddInvite(ctx context.Context, invite Invite) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.invites[invite.Hash] = invite
+	return nil
+}
+
+//UseInvite removes an invite and returns it, so every code works only once.
+//Unknown and expired invites give ErrNotFound.
+func (db *MemoryDB) UseInvite(ctx context.Context, hash string) (Invite, error) {
+	if err := checkContext(ctx); err != nil {
+		return Invite{}, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	invite, found := db.invites[hash]
+	delete(db.invites, hash)
+	if !found || time.Now().After(invite.ExpiresAt) {
+		return Invite{}, ErrNotFound
+	}
+	return invite, nil
+}
+
+//AddPasswordReset stores a new password reset token
+func (db *MemoryDB) AddPasswordReset(ctx context.Context, reset PasswordReset) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.passwordResets[reset.Hash] = reset
+	return nil
+}
+
+//UsePasswordReset removes a password reset token and returns it. Unknown
+//and expired tokens give ErrNotFound.
+func (db *MemoryDB) UsePasswordReset(ctx context.Context, hash string) (PasswordReset, error) {
+	if err := checkContext(ctx); err != nil {
+		return PasswordReset{}, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	reset, found := db.passwordResets[hash]
+	delete(db.passwordResets, hash)
+	if !found || time.Now().After(reset.ExpiresAt) {
+		return PasswordReset{}, ErrNotFound
+	}
+	return reset, nil
+}
+
+//RevokeUserPasswordResets removes every password reset token of a user
+func (db *MemoryDB) RevokeUserPasswordResets(ctx context.Context, username string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for hash, reset := range db.passwordResets {
+		if reset.Username == username {
+			delete(db.passwordResets, hash)
+		}
+	}
+	return nil
+}
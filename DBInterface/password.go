@@ -0,0 +1,81 @@
+package dbinterface
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//ErrWeakPassword is returned when a new password is rejected by PasswordPolicy
+var ErrWeakPassword = errors.New("password is too weak")
+
+//PasswordPolicy is called with every new password before it is hashed and
+//stored. Replace it to change the rules, errors should wrap ErrWeakPassword.
+var PasswordPolicy = MinLengthPolicy(8)
+
+//MinLengthPolicy rejects passwords shorter than n characters
+func MinLengthPolicy(n int) func(string) error {
+	return func(password string) error {
+		if len([]rune(password)) < n {
+			return fmt.Errorf("%w: it must be at least %d characters", ErrWeakPassword, n)
+		}
+		return nil
+	}
+}
+
+//dummyHash is compared against when a user does not exist so that looking
+//up unknown usernames takes as long as checking a wrong password
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+//hashPassword checks the password against PasswordPolicy and returns a salted hash
+func hashPassword(password string) (string, error) {
+	if PasswordPolicy != nil {
+		if err := PasswordPolicy(password); err != nil {
+			return "", err
+		}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func isPasswordHash(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") || strings.HasPrefix(stored, "$2y$")
+}
+
+//checkPassword compares a password with what is stored for the user. Users
+//created before passwords were hashed still have plaintext stored, for them
+//and for hashes with an outdated cost needsRehash is true.
+func checkPassword(stored, password string) (ok bool, needsRehash bool) {
+	if !isPasswordHash(stored) {
+		ok = subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+		return ok, ok
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(stored))
+	return true, err != nil || cost < bcrypt.DefaultCost
+}
+
+//rehashPassword hashes a password that already passed checkPassword. The
+//policy is skipped so legacy users with short passwords can still log in.
+func rehashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+//noSuchUser spends the same time as checking a password
+func noSuchUser(password string) {
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
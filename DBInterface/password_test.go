@@ -0,0 +1,150 @@
+package dbinterface
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//addPlaintextUser stores a user the way they were stored before passwords
+//were hashed
+func addPlaintextUser(t *testing.T, db Store, user User) {
+	t.Helper()
+	switch db := db.(type) {
+	case *MemoryDB:
+		db.users = append(db.users, user)
+	case *BoltDB:
+		err := db.db.Update(func(tx *bolt.Tx) error {
+			return putJSON(tx.Bucket(usersBucket), user.Username, user)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("no plaintext users for %T", db)
+	}
+}
+
+func storedPassword(t *testing.T, db Store, username string) string {
+	t.Helper()
+	user, err := db.GetUser(context.Background(), username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.Password
+}
+
+func TestAddUserHashesPassword(t *testing.T) {
+	ctx := context.Background()
+
+	for name, db := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := db.AddUser(ctx, "p", "correct horse", RolePlayer); err != nil {
+				t.Fatal(err)
+			}
+
+			stored := storedPassword(t, db, "p")
+			if stored == "correct horse" {
+				t.Fatal("password is stored in plaintext")
+			}
+			if err := bcrypt.CompareHashAndPassword([]byte(stored), []byte("correct horse")); err != nil {
+				t.Errorf("stored password is not a bcrypt hash of the password: %v", err)
+			}
+
+			if role, err := db.CheckUser(ctx, "p", "correct horse"); err != nil || role != RolePlayer {
+				t.Errorf("CheckUser() = %q, %v, want %q", role, err, RolePlayer)
+			}
+			if _, err := db.CheckUser(ctx, "p", "wrong horse"); err != ErrInvalidCredentials {
+				t.Errorf("CheckUser() with a wrong password error = %v, want ErrInvalidCredentials", err)
+			}
+			if _, err := db.CheckUser(ctx, "nobody", "correct horse"); err != ErrInvalidCredentials {
+				t.Errorf("CheckUser() for an unknown user error = %v, want ErrInvalidCredentials", err)
+			}
+		})
+	}
+}
+
+func TestCheckUserRehashesPlaintext(t *testing.T) {
+	ctx := context.Background()
+
+	for name, db := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// Legacy passwords can be shorter than the policy allows
+			addPlaintextUser(t, db, User{Username: "p", Password: "short", UserRole: RolePlayer})
+
+			if _, err := db.CheckUser(ctx, "p", "wrong"); err != ErrInvalidCredentials {
+				t.Errorf("CheckUser() with a wrong password error = %v, want ErrInvalidCredentials", err)
+			}
+			if stored := storedPassword(t, db, "p"); stored != "short" {
+				t.Errorf("a failed login changed the stored password to %q", stored)
+			}
+
+			if role, err := db.CheckUser(ctx, "p", "short"); err != nil || role != RolePlayer {
+				t.Fatalf("CheckUser() = %q, %v, want %q", role, err, RolePlayer)
+			}
+			stored := storedPassword(t, db, "p")
+			if !isPasswordHash(stored) {
+				t.Fatalf("stored password = %q, want a bcrypt hash", stored)
+			}
+
+			// After the rehash the hash decides
+			if role, err := db.CheckUser(ctx, "p", "short"); err != nil || role != RolePlayer {
+				t.Errorf("CheckUser() after the rehash = %q, %v, want %q", role, err, RolePlayer)
+			}
+			if _, err := db.CheckUser(ctx, "p", "wrong"); err != ErrInvalidCredentials {
+				t.Errorf("CheckUser() with a wrong password after the rehash error = %v, want ErrInvalidCredentials", err)
+			}
+			if _, err := db.CheckUser(ctx, "p", stored); err != ErrInvalidCredentials {
+				t.Errorf("CheckUser() with the hash as password error = %v, want ErrInvalidCredentials", err)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	ctx := context.Background()
+	errNoDigit := errors.New("no digit")
+	defer func(policy func(string) error) { PasswordPolicy = policy }(PasswordPolicy)
+
+	tests := []struct {
+		name     string
+		policy   func(string) error
+		password string
+		err      error
+	}{
+		{"default policy accepts", MinLengthPolicy(8), "long enough", nil},
+		{"default policy rejects", MinLengthPolicy(8), "short", ErrWeakPassword},
+		{"custom policy rejects", func(string) error { return errNoDigit }, "long enough", errNoDigit},
+		{"no policy", nil, "x", nil},
+	}
+
+	for name, db := range testStores(t) {
+		for _, test := range tests {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				PasswordPolicy = test.policy
+				username := test.name
+
+				err := db.AddUser(ctx, username, test.password, RolePlayer)
+				if !errors.Is(err, test.err) || (test.err == nil && err != nil) {
+					t.Fatalf("AddUser() error = %v, want %v", err, test.err)
+				}
+				if test.err != nil {
+					if _, err := db.GetUser(ctx, username); err != ErrNotFound {
+						t.Errorf("GetUser() error = %v, want ErrNotFound", err)
+					}
+					return
+				}
+
+				// Changing the password goes through the policy as well
+				PasswordPolicy = func(string) error { return ErrWeakPassword }
+				err = db.UpdateUser(ctx, User{Username: username, Password: "new password", UserRole: RolePlayer}, username)
+				if !errors.Is(err, ErrWeakPassword) {
+					t.Errorf("UpdateUser() error = %v, want ErrWeakPassword", err)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package dbinterface
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+//CharacterPatch is a JSON merge patch (RFC 7396) for a character. Fields
+//that are left out keep their value, null resets a field and objects are
+//merged field by field. Arrays are replaced as a whole.
+type CharacterPatch map[string]interface{}
+
+//CharacterFields returns the fields of ch at the JSON paths, like
+//"hp.maxHP", as a patch that would set them to the values they have in ch
+func CharacterFields(ch Character, paths ...string) (CharacterPatch, error) {
+	doc, err := characterDoc(ch)
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string]interface{}{}
+	for _, path := range paths {
+		keys := strings.Split(path, ".")
+		var value interface{} = doc
+		for _, key := range keys {
+			fields, _ := value.(map[string]interface{})
+			value = fields[key]
+		}
+
+		target := res
+		for _, key := range keys[:len(keys)-1] {
+			next, ok := target[key].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				target[key] = next
+			}
+			target = next
+		}
+		target[keys[len(keys)-1]] = value
+	}
+	return CharacterPatch(res), nil
+}
+
+//characterDoc turns ch into the generic form of its JSON
+func characterDoc(ch Character) (map[string]interface{}, error) {
+	data, err := json.Marshal(ch)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+//Apply returns ch with the patch applied
+func (patch CharacterPatch) Apply(ch Character) (Character, error) {
+	if _, err := patch.update(); err != nil {
+		return Character{}, err
+	}
+
+	doc, err := characterDoc(ch)
+	if err != nil {
+		return Character{}, err
+	}
+
+	data, err := json.Marshal(mergePatch(doc, map[string]interface{}(patch)))
+	if err != nil {
+		return Character{}, err
+	}
+	var res Character
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&res); err != nil {
+		return Character{}, err
+	}
+	return res, nil
+}
+
+//mergePatch applies patch to target as described in RFC 7396
+func mergePatch(target, patch interface{}) interface{} {
+	fields, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	doc, ok := target.(map[string]interface{})
+	if !ok {
+		doc = map[string]interface{}{}
+	}
+	for key, value := range fields {
+		if value == nil {
+			delete(doc, key)
+		} else {
+			doc[key] = mergePatch(doc[key], value)
+		}
+	}
+	return doc
+}
+
+//update turns the patch into a Mongo update that only touches the fields in
+//the patch. An empty patch gives an empty update.
+func (patch CharacterPatch) update() (bson.M, error) {
+	set := bson.M{}
+	unset := bson.M{}
+	err := patchFields(reflect.TypeOf(Character{}), "", "", patch, set, unset)
+	if err != nil {
+		return nil, err
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return update, nil
+}
+
+//matches reports if the fields in the patch have the same values in ch, so
+//applying the patch would not change ch. A nil patch matches everything.
+func (patch CharacterPatch) matches(ch Character) (bool, error) {
+	patched, err := patch.Apply(ch)
+	if err != nil {
+		return false, err
+	}
+	want, err := json.Marshal(ch)
+	if err != nil {
+		return false, err
+	}
+	got, err := json.Marshal(patched)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(got, want), nil
+}
+
+//filter turns the patch into a Mongo filter that matches documents where the
+//fields in the patch have its values. Zero values also match documents that
+//do not have the field, like characters stored before it was added.
+func (patch CharacterPatch) filter() (bson.M, error) {
+	set := bson.M{}
+	unset := bson.M{}
+	err := patchFields(reflect.TypeOf(Character{}), "", "", patch, set, unset)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{}
+	for field, value := range set {
+		if reflect.ValueOf(value).IsZero() {
+			filter[field] = bson.M{"$in": bson.A{value, nil}}
+		} else {
+			filter[field] = value
+		}
+	}
+	for field := range unset {
+		filter[field] = nil
+	}
+	return filter, nil
+}
+
+//patchFields walks the patch next to the struct t. JSON names are matched
+//against the json tags and turned into the lowercase keys the Mongo driver
+//stores fields under. Values are decoded into the type of their field so a
+//patch with wrong types is turned down before it reaches the database.
+func patchFields(t reflect.Type, jsonPath, bsonPath string, patch map[string]interface{}, set, unset bson.M) error {
+	for key, value := range patch {
+		field, found := jsonField(t, key)
+		if !found {
+			return &PatchError{Field: jsonPath + key, Reason: "unknown field"}
+		}
+		fieldJSON := jsonPath + key
+		fieldBSON := bsonPath + strings.ToLower(field.Name)
+
+		if value == nil {
+			unset[fieldBSON] = ""
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok && field.Type.Kind() == reflect.Struct {
+			if err := patchFields(field.Type, fieldJSON+".", fieldBSON+".", nested, set, unset); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		typed := reflect.New(field.Type)
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(typed.Interface()); err != nil {
+			return &PatchError{Field: fieldJSON, Reason: err.Error()}
+		}
+		set[fieldBSON] = typed.Elem().Interface()
+	}
+	return nil
+}
+
+//jsonField finds the field of t that has name as its JSON name
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+		if tag == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
@@ -0,0 +1,198 @@
+package dbinterface
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func decodePatch(t *testing.T, body string) CharacterPatch {
+	t.Helper()
+	var patch CharacterPatch
+	if err := json.Unmarshal([]byte(body), &patch); err != nil {
+		t.Fatal(err)
+	}
+	return patch
+}
+
+func TestCharacterPatchUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		patch  string
+		update bson.M
+		field  string
+	}{
+		{
+			name:   "empty",
+			patch:  `{}`,
+			update: bson.M{},
+		},
+		{
+			name:   "top level field",
+			patch:  `{"level": 3}`,
+			update: bson.M{"$set": bson.M{"level": 3}},
+		},
+		{
+			name:   "nested field",
+			patch:  `{"hp": {"currHP": 7}}`,
+			update: bson.M{"$set": bson.M{"hp.currhp": 7}},
+		},
+		{
+			name:   "nested null",
+			patch:  `{"hp": {"tempHP": null}, "owner": null}`,
+			update: bson.M{"$unset": bson.M{"hp.temphp": "", "owner": ""}},
+		},
+		{
+			name:  "unknown field",
+			patch: `{"charisma": 12}`,
+			field: "charisma",
+		},
+		{
+			name:  "unknown nested field",
+			patch: `{"stats": {"luck": 12}}`,
+			field: "stats.luck",
+		},
+		{
+			name:  "wrong type",
+			patch: `{"level": "three"}`,
+			field: "level",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			update, err := decodePatch(t, test.patch).update()
+			if test.field != "" {
+				patchErr, ok := err.(*PatchError)
+				if !ok {
+					t.Fatalf("update() error = %v, want a *PatchError", err)
+				}
+				if patchErr.Field != test.field {
+					t.Errorf("PatchError.Field = %q, want %q", patchErr.Field, test.field)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("update() error = %v", err)
+			}
+			if !reflect.DeepEqual(update, test.update) {
+				t.Errorf("update() = %v, want %v", update, test.update)
+			}
+		})
+	}
+}
+
+func TestCharacterPatchApply(t *testing.T) {
+	ch := Character{CharacterName: "Vex", Level: 2, Owner: "p"}
+	ch.Hp.MaxHP = 10
+	ch.Hp.CurrHP = 10
+	ch.Hp.TempHP = 4
+
+	tests := []struct {
+		name    string
+		patch   string
+		want    func(Character) Character
+		wantErr bool
+	}{
+		{
+			name:  "merges nested objects",
+			patch: `{"hp": {"currHP": 6}}`,
+			want: func(ch Character) Character {
+				ch.Hp.CurrHP = 6
+				return ch
+			},
+		},
+		{
+			name:  "nested null resets the field",
+			patch: `{"hp": {"tempHP": null}}`,
+			want: func(ch Character) Character {
+				ch.Hp.TempHP = 0
+				return ch
+			},
+		},
+		{
+			name:    "unknown field",
+			patch:   `{"hp": {"shield": 2}}`,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := decodePatch(t, test.patch).Apply(ch)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("Apply() accepted the patch")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if want := test.want(ch); !reflect.DeepEqual(res, want) {
+				t.Errorf("Apply() = %+v, want %+v", res, want)
+			}
+		})
+	}
+}
+
+func TestCharacterPatchFilter(t *testing.T) {
+	ch := Character{CharacterName: "Vex", Level: 2}
+	ch.Hp.MaxHP = 10
+
+	expect, err := CharacterFields(ch, "level", "hp.maxHP", "hp.currHP", "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := expect.filter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Zero values also match documents without the field
+	want := bson.M{
+		"level":     2,
+		"hp.maxhp":  10,
+		"hp.currhp": bson.M{"$in": bson.A{0, nil}},
+		"owner":     bson.M{"$in": bson.A{"", nil}},
+	}
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("filter() = %v, want %v", filter, want)
+	}
+}
+
+func TestCharacterPatchMatches(t *testing.T) {
+	ch := Character{CharacterName: "Vex", Level: 2}
+	ch.Hp.MaxHP = 10
+	expect, err := CharacterFields(ch, "level", "hp.maxHP")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Character)
+		want   bool
+	}{
+		{"unchanged", func(*Character) {}, true},
+		{"other field changed", func(ch *Character) { ch.Hp.CurrHP = 4 }, true},
+		{"top level field changed", func(ch *Character) { ch.Level = 3 }, false},
+		{"nested field changed", func(ch *Character) { ch.Hp.MaxHP = 8 }, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changed := ch
+			test.change(&changed)
+			ok, err := expect.matches(changed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != test.want {
+				t.Errorf("matches() = %v, want %v", ok, test.want)
+			}
+		})
+	}
+}
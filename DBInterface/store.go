@@ -0,0 +1,79 @@
+package dbinterface
+
+import "context"
+
+//UserStore handles operations on users
+type UserStore interface {
+	AddUser(ctx context.Context, username, password, userRole string) error
+	CheckUser(ctx context.Context, username, password string) (string, error)
+	UpdateUser(ctx context.Context, user User, userToUpdate string) error
+	DeleteUser(ctx context.Context, name string) error
+	GetAllUsers(ctx context.Context) ([]string, error)
+	GetUser(ctx context.Context, username string) (User, error)
+	SetPassword(ctx context.Context, username, password string) error
+	SetEmail(ctx context.Context, username, email string) error
+}
+
+//CampaignStore handles operations on campaigns
+type CampaignStore interface {
+	AddCampain(ctx context.Context, campain Campaign) (string, error)
+	UpdateCampaign(ctx context.Context, id string, campaignToUpdate Campaign) error
+	AddCampaignPlayer(ctx context.Context, id, username string) error
+	RemoveCampaign(ctx context.Context, id string) error
+	GetUserCampaign(ctx context.Context, username string) ([]Campaign, error)
+	GetDMCampaign(ctx context.Context, username string) ([]Campaign, error)
+	GetAllCampains(ctx context.Context) ([]Campaign, error)
+	GetCampaignByID(ctx context.Context, id string) (Campaign, error)
+	GetCampaignsByName(ctx context.Context, name string) ([]Campaign, error)
+	GetCharacterCampaign(ctx context.Context, characterID string) (Campaign, error)
+}
+
+//CharacterStore handles operations on characters
+type CharacterStore interface {
+	AddCharacter(ctx context.Context, campaignID string, character Character) (string, error)
+	GetCharacterByID(ctx context.Context, id string) (Character, error)
+	GetMultiCharacter(ctx context.Context, ids []string) ([]MultiCharacterGetReturn, error)
+	UpdateCharacter(ctx context.Context, id string, ch Character) error
+	PatchCharacter(ctx context.Context, id string, patch, expect CharacterPatch) (Character, error)
+	RemoveCharacter(ctx context.Context, id string) error
+	GetCharactersByOwner(ctx context.Context, owner string) ([]MultiCharacterGetReturn, error)
+}
+
+//TokenStore keeps track of issued refresh tokens and password reset tokens
+type TokenStore interface {
+	AddRefreshToken(ctx context.Context, token RefreshToken) error
+	UseRefreshToken(ctx context.Context, hash string) (RefreshToken, error)
+	RevokeRefreshToken(ctx context.Context, hash string) error
+	RevokeUserRefreshTokens(ctx context.Context, username string) error
+	AddPasswordReset(ctx context.Context, reset PasswordReset) error
+	UsePasswordReset(ctx context.Context, hash string) (PasswordReset, error)
+	RevokeUserPasswordResets(ctx context.Context, username string) error
+}
+
+//APIKeyStore handles the API keys of users
+type APIKeyStore interface {
+	AddAPIKey(ctx context.Context, username string, key APIKey) error
+	RemoveAPIKey(ctx context.Context, username, id string) error
+	FindAPIKey(ctx context.Context, id string) (User, error)
+}
+
+//InviteStore keeps the invite codes used to register
+type InviteStore interface {
+	AddInvite(ctx context.Context, invite Invite) error
+	UseInvite(ctx context.Context, hash string) (Invite, error)
+}
+
+//Store is implemented by every storage backend the server can run on.
+//Every method takes the context of the request it serves. Failures are
+//reported with the errors in errors.go, so ErrNotFound, ErrAlreadyExists,
+//ErrInvalidID, ErrConflict and ErrTimeout mean the same on every backend.
+type Store interface {
+	UserStore
+	CampaignStore
+	CharacterStore
+	TokenStore
+	APIKeyStore
+	InviteStore
+}
+
+var _ Store = (*DBInterface)(nil)
@@ -0,0 +1,158 @@
+package dbinterface
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+//testStores returns a fresh store of every backend that runs without a server
+func testStores(t *testing.T) map[string]Store {
+	return map[string]Store{
+		"memory": NewMemoryDB(),
+		"bolt":   testBoltDB(t),
+	}
+}
+
+func TestDeleteUserReferences(t *testing.T) {
+	ctx := context.Background()
+
+	for name, db := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, user := range []string{"dm", "p", "other"} {
+				if err := db.AddUser(ctx, user, "password", RolePlayer); err != nil {
+					t.Fatal(err)
+				}
+			}
+			campaignID, err := db.AddCampain(ctx, Campaign{Name: "c", DM: "dm", Players: []string{"p", "other"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			owned, err := db.AddCharacter(ctx, campaignID, Character{CharacterName: "Vex", Owner: "p"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			other, err := db.AddCharacter(ctx, campaignID, Character{CharacterName: "Vax", Owner: "other"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expires := time.Now().Add(time.Hour)
+			if err := db.AddRefreshToken(ctx, RefreshToken{Hash: "refresh", Username: "p", ExpiresAt: expires}); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.AddPasswordReset(ctx, PasswordReset{Hash: "reset", Username: "p", ExpiresAt: expires}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := db.DeleteUser(ctx, "p"); err != nil {
+				t.Fatal(err)
+			}
+
+			camp, err := db.GetCampaignByID(ctx, campaignID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(camp.Players) != 1 || camp.Players[0] != "other" {
+				t.Errorf("players = %v, want [other]", camp.Players)
+			}
+			if ch, err := db.GetCharacterByID(ctx, owned); err != nil || ch.Owner != "dm" {
+				t.Errorf("owner of the deleted user's character = %q, %v, want dm", ch.Owner, err)
+			}
+			if ch, err := db.GetCharacterByID(ctx, other); err != nil || ch.Owner != "other" {
+				t.Errorf("owner of another character = %q, %v, want other", ch.Owner, err)
+			}
+			if _, err := db.UseRefreshToken(ctx, "refresh"); err != ErrNotFound {
+				t.Errorf("UseRefreshToken error = %v, want ErrNotFound", err)
+			}
+			if _, err := db.UsePasswordReset(ctx, "reset"); err != ErrNotFound {
+				t.Errorf("UsePasswordReset error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestAddCampaignPlayer(t *testing.T) {
+	ctx := context.Background()
+
+	for name, db := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			campaignID, err := db.AddCampain(ctx, Campaign{Name: "c", DM: "dm", Players: []string{"p0"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Players joining at the same time must not drop each other
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(player string) {
+					defer wg.Done()
+					if err := db.AddCampaignPlayer(ctx, campaignID, player); err != nil {
+						t.Error(err)
+					}
+				}("p" + strconv.Itoa(i))
+			}
+			wg.Wait()
+
+			camp, err := db.GetCampaignByID(ctx, campaignID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(camp.Players) != 20 {
+				t.Errorf("%d players, want 20: %v", len(camp.Players), camp.Players)
+			}
+
+			if err := db.AddCampaignPlayer(ctx, "000000000000000000000000", "p"); err != ErrNotFound {
+				t.Errorf("unknown campaign error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestPatchCharacterExpect(t *testing.T) {
+	ctx := context.Background()
+
+	for name, db := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			campaignID, err := db.AddCampain(ctx, Campaign{Name: "c", DM: "dm"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ch := Character{CharacterName: "Vex"}
+			ch.Hp.MaxHP = 20
+			ch.Hp.CurrHP = 10
+			id, err := db.AddCharacter(ctx, campaignID, ch)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Two patches checked against the same character, each is fine
+			// on its own but together they leave currHP above maxHP
+			expect, err := CharacterFields(ch, "hp.maxHP", "hp.currHP")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.PatchCharacter(ctx, id, CharacterPatch{"hp": map[string]interface{}{"maxHP": 12}}, expect); err != nil {
+				t.Fatal(err)
+			}
+			_, err = db.PatchCharacter(ctx, id, CharacterPatch{"hp": map[string]interface{}{"currHP": 15}}, expect)
+			if err != ErrConflict {
+				t.Errorf("second patch error = %v, want ErrConflict", err)
+			}
+
+			stored, err := db.GetCharacterByID(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Hp.MaxHP != 12 || stored.Hp.CurrHP != 10 {
+				t.Errorf("hp = %d/%d, want 10/12", stored.Hp.CurrHP, stored.Hp.MaxHP)
+			}
+
+			if _, err := db.PatchCharacter(ctx, "000000000000000000000000", CharacterPatch{"level": 2}, expect); err != ErrNotFound {
+				t.Errorf("unknown character error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
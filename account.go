@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+)
+
+// myCampaignsGet is the answer to /myCampaigns
+type myCampaignsGet struct {
+	Player []dndinterface.Campaign `json:"player"`
+	DM     []dndinterface.Campaign `json:"dm"`
+}
+
+// myCampaigns returns the campaigns the logged in user plays in or runs
+func myCampaigns(w http.ResponseWriter, r *http.Request) {
+	username := requestClaims(r).Username
+
+	player, err := db.GetUserCampaign(r.Context(), username)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	dm, err := db.GetDMCampaign(r.Context(), username)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(myCampaignsGet{Player: player, DM: dm})
+}
+
+// myCharacters returns the characters owned by the logged in user
+func myCharacters(w http.ResponseWriter, r *http.Request) {
+	characters, err := db.GetCharactersByOwner(r.Context(), requestClaims(r).Username)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(characters)
+}
+
+// profileGet is the answer to /me
+type profileGet struct {
+	Username string `json:"username"`
+	UserRole string `json:"userRole"`
+	Email    string `json:"email"`
+}
+
+// me returns the profile of the logged in user
+func me(w http.ResponseWriter, r *http.Request) {
+	user, err := db.GetUser(r.Context(), requestClaims(r).Username)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(profileGet{Username: user.Username, UserRole: user.UserRole, Email: user.Email})
+}
+
+// validEmail reports if email is a plain email address
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+type changeEmailPost struct {
+	Password string `json:"password"`
+	Email    string `json:"email"`
+}
+
+// changeEmail sets the address password resets are sent to
+func changeEmail(w http.ResponseWriter, r *http.Request) {
+	var postData changeEmailPost
+	err := json.NewDecoder(r.Body).Decode(&postData)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	if postData.Email != "" && !validEmail(postData.Email) {
+		writeInvalid(w, fieldError{Field: "email", Message: "Not a valid email address"})
+		return
+	}
+	if !confirmPassword(w, r, postData.Password) {
+		return
+	}
+
+	if err := db.SetEmail(r.Context(), requestClaims(r).Username, postData.Email); err != nil {
+		writeStoreError(w, err)
+	}
+}
+
+// confirmPassword checks the password of the logged in user before changes to
+// the account. Wrong passwords count as failed logins.
+func confirmPassword(w http.ResponseWriter, r *http.Request, password string) bool {
+	username := requestClaims(r).Username
+	ip := clientIP(r)
+	if wait := logins.reserve(username, ip, time.Now()); wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return false
+	}
+
+	_, err := db.CheckUser(r.Context(), username, password)
+	logins.done(username, ip, err, time.Now())
+	if err == dndinterface.ErrInvalidCredentials {
+		writeForbidden(w)
+		return false
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return false
+	}
+	return true
+}
+
+type changePasswordPost struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+}
+
+// changePassword sets a new password for the logged in user. Every other
+// session of the user is signed out.
+func changePassword(w http.ResponseWriter, r *http.Request) {
+	var postData changePasswordPost
+	err := json.NewDecoder(r.Body).Decode(&postData)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	if !confirmPassword(w, r, postData.OldPassword) {
+		return
+	}
+
+	claims := requestClaims(r)
+	if err := db.SetPassword(r.Context(), claims.Username, postData.NewPassword); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if err := db.RevokeUserRefreshTokens(r.Context(), claims.Username); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	if _, err := issueTokens(w, r, claims.Username, claims.Type); err != nil {
+		writeStoreError(w, err)
+	}
+}
+
+type deleteAccountPost struct {
+	Password string `json:"password"`
+}
+
+// canChangeUser writes the error response unless username can be deleted, or
+// given role when role is not empty. Roles stored before they were lower
+// cased are compared without regard to case. The last admin has to stay so the server can still be managed, and users that run
+// campaigns have to hand them over or delete them first so no campaign is
+// left without a DM.
+func canChangeUser(w http.ResponseWriter, r *http.Request, username, role string) bool {
+	user, err := db.GetUser(r.Context(), username)
+	if err != nil {
+		writeStoreError(w, err)
+		return false
+	}
+	if strings.EqualFold(user.UserRole, role) {
+		return true
+	}
+
+	if strings.EqualFold(user.UserRole, dndinterface.RoleAdmin) && !strings.EqualFold(role, dndinterface.RoleAdmin) {
+		last, err := isLastAdmin(r.Context(), username)
+		if err != nil {
+			writeStoreError(w, err)
+			return false
+		}
+		if last {
+			writeError(w, http.StatusConflict, codeConflict, "The last admin can not be deleted or lose the admin role")
+			return false
+		}
+	}
+
+	if strings.EqualFold(role, dndinterface.RoleAdmin) || strings.EqualFold(role, dndinterface.RoleDM) {
+		return true
+	}
+	campaigns, err := db.GetDMCampaign(r.Context(), username)
+	if err != nil {
+		writeStoreError(w, err)
+		return false
+	}
+	if len(campaigns) > 0 {
+		writeError(w, http.StatusConflict, codeConflict,
+			"The user still runs campaigns, hand them over to another DM or delete them first")
+		return false
+	}
+	return true
+}
+
+// isLastAdmin reports if no other user than username is an admin
+func isLastAdmin(ctx context.Context, username string) (bool, error) {
+	names, err := db.GetAllUsers(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range names {
+		if name == username {
+			continue
+		}
+		user, err := db.GetUser(ctx, name)
+		if err == dndinterface.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if strings.EqualFold(user.UserRole, dndinterface.RoleAdmin) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// deleteAccount deletes the logged in user after checking the password
+func deleteAccount(w http.ResponseWriter, r *http.Request) {
+	var postData deleteAccountPost
+	err := json.NewDecoder(r.Body).Decode(&postData)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	if !confirmPassword(w, r, postData.Password) {
+		return
+	}
+
+	username := requestClaims(r).Username
+	if !canChangeUser(w, r, username, "") {
+		return
+	}
+	if err := db.DeleteUser(r.Context(), username); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	clearTokenCookies(w)
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+	"github.com/gorilla/mux"
+)
+
+const apiV2 = "/api/v2"
+
+// registerV2 adds the resource oriented routes under /api/v2. They use the
+// same stores and access checks as the v1 routes.
+func registerV2(router *mux.Router) {
+	v2 := router.PathPrefix(apiV2).Subrouter()
+
+	v2.Handle("/campaigns", isAuthorized(listCampaignsV2)).Methods("GET")
+	v2.Handle("/campaigns", isAuthorized(createCampaignV2)).Methods("POST")
+	v2.Handle("/campaigns/{id}", isAuthorized(getCampaignV2)).Methods("GET")
+	v2.Handle("/campaigns/{id}", isAuthorized(replaceCampaignV2)).Methods("PUT")
+	v2.Handle("/campaigns/{id}", isAuthorized(patchCampaignV2)).Methods("PATCH")
+	v2.Handle("/campaigns/{id}", isAuthorized(deleteCampaignV2)).Methods("DELETE")
+	v2.Handle("/campaigns/{id}/characters", isAuthorized(listCampaignCharactersV2)).Methods("GET")
+	v2.Handle("/campaigns/{id}/characters", isAuthorized(createCharacterV2)).Methods("POST")
+
+	v2.Handle("/characters/{id}", isAuthorized(getCharacterV2)).Methods("GET")
+	v2.Handle("/characters/{id}", isAuthorized(replaceCharacterV2)).Methods("PUT")
+	v2.Handle("/characters/{id}", isAuthorized(patchCharacterV2)).Methods("PATCH")
+	v2.Handle("/characters/{id}", isAuthorized(deleteCharacterV2)).Methods("DELETE")
+
+	v2.Handle("/users", isAuthorized(getUserList)).Methods("GET")
+	v2.Handle("/users", isAuthorized(createUserV2)).Methods("POST")
+	v2.Handle("/users/{name}", isAuthorized(getUserV2)).Methods("GET")
+	v2.Handle("/users/{name}", isAuthorized(replaceUserV2)).Methods("PUT")
+	v2.Handle("/users/{name}", isAuthorized(patchUserV2)).Methods("PATCH")
+	v2.Handle("/users/{name}", isAuthorized(deleteUserV2)).Methods("DELETE")
+}
+
+// writeCreated answers a POST with the location and body of the new resource
+func writeCreated(w http.ResponseWriter, location string, body interface{}) {
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(body)
+}
+
+// listCampaignsV2 returns every campaign for admins and the campaigns the
+// caller plays in or runs for everybody else
+func listCampaignsV2(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+	if hasRole(claims, dndinterface.RoleAdmin) {
+		getAllCampaigns(w, r)
+		return
+	}
+
+	player, err := db.GetUserCampaign(r.Context(), claims.Username)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	dm, err := db.GetDMCampaign(r.Context(), claims.Username)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	campaigns := []dndinterface.Campaign{}
+	seen := map[string]bool{}
+	for _, campaign := range append(dm, player...) {
+		if !seen[campaign.ID] {
+			seen[campaign.ID] = true
+			campaigns = append(campaigns, campaign)
+		}
+	}
+	json.NewEncoder(w).Encode(campaigns)
+}
+
+func createCampaignV2(w http.ResponseWriter, r *http.Request) {
+	var campaign dndinterface.Campaign
+	if err := json.NewDecoder(r.Body).Decode(&campaign); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	if campaign, ok := createCampaign(w, r, campaign); ok {
+		writeCreated(w, apiV2+"/campaigns/"+campaign.ID, campaign)
+	}
+}
+
+// campaignV2 loads the campaign in the path. On failure the error response
+// is already written.
+func campaignV2(w http.ResponseWriter, r *http.Request) (dndinterface.Campaign, bool) {
+	campaign, err := db.GetCampaignByID(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, err)
+		return dndinterface.Campaign{}, false
+	}
+	return campaign, true
+}
+
+func getCampaignV2(w http.ResponseWriter, r *http.Request) {
+	campaign, ok := campaignV2(w, r)
+	if !ok {
+		return
+	}
+	if !isCampaignMember(r, campaign) {
+		writeForbidden(w)
+		return
+	}
+	json.NewEncoder(w).Encode(campaign)
+}
+
+func replaceCampaignV2(w http.ResponseWriter, r *http.Request) {
+	var replacement dndinterface.Campaign
+	if err := json.NewDecoder(r.Body).Decode(&replacement); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	campaign, ok := campaignV2(w, r)
+	if !ok {
+		return
+	}
+	if !canManageCampaign(r, campaign) {
+		writeForbidden(w)
+		return
+	}
+	if !checkCampaign(w, r, "", replacement) || !canChangeDM(w, r, campaign, replacement.DM) {
+		return
+	}
+
+	if err := db.UpdateCampaign(r.Context(), campaign.ID, replacement); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// patchCampaignV2 changes only the fields that are in the body
+func patchCampaignV2(w http.ResponseWriter, r *http.Request) {
+	campaign, ok := campaignV2(w, r)
+	if !ok {
+		return
+	}
+	if !canManageCampaign(r, campaign) {
+		writeForbidden(w)
+		return
+	}
+
+	old := campaign
+	if err := json.NewDecoder(r.Body).Decode(&campaign); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	id := old.ID
+	campaign.ID = id
+	if !checkCampaign(w, r, "", campaign) || !canChangeDM(w, r, old, campaign.DM) {
+		return
+	}
+
+	if err := db.UpdateCampaign(r.Context(), id, campaign); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(campaign)
+}
+
+func deleteCampaignV2(w http.ResponseWriter, r *http.Request) {
+	campaign, ok := campaignV2(w, r)
+	if !ok {
+		return
+	}
+	if !canManageCampaign(r, campaign) {
+		writeForbidden(w)
+		return
+	}
+
+	if err := db.RemoveCampaign(r.Context(), campaign.ID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listCampaignCharactersV2(w http.ResponseWriter, r *http.Request) {
+	campaign, ok := campaignV2(w, r)
+	if !ok {
+		return
+	}
+	if !isCampaignMember(r, campaign) {
+		writeForbidden(w)
+		return
+	}
+
+	visible, err := visibleCharacters(r, campaign.Characters)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if visible == nil {
+		visible = []dndinterface.MultiCharacterGetReturn{}
+	}
+	json.NewEncoder(w).Encode(visible)
+}
+
+func createCharacterV2(w http.ResponseWriter, r *http.Request) {
+	var ch dndinterface.Character
+	if err := json.NewDecoder(r.Body).Decode(&ch); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	campaign, ok := campaignV2(w, r)
+	if !ok {
+		return
+	}
+	id, ok := createCharacter(w, r, campaign, "", ch)
+	if !ok {
+		return
+	}
+
+	created, err := db.GetCharacterByID(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeCreated(w, apiV2+"/characters/"+id, dndinterface.MultiCharacterGetReturn{ID: id, Character: created})
+}
+
+func getCharacterV2(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if ch, ok := loadCharacter(w, r, id); ok {
+		json.NewEncoder(w).Encode(dndinterface.MultiCharacterGetReturn{ID: id, Character: ch})
+	}
+}
+
+func replaceCharacterV2(w http.ResponseWriter, r *http.Request) {
+	var ch dndinterface.Character
+	if err := json.NewDecoder(r.Body).Decode(&ch); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	if saveCharacter(w, r, mux.Vars(r)["id"], "", ch) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// patchCharacterV2 changes only the fields in the body, a JSON merge patch
+// (RFC 7396). Only those fields are written, so clients that change
+// different fields at the same time do not undo each other.
+func patchCharacterV2(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "" && mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, codeUnsupportedType, "Send a JSON merge patch as application/merge-patch+json")
+		return
+	}
+
+	var patch dndinterface.CharacterPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	old, handOver, ok := editCharacter(w, r, id)
+	if !ok {
+		return
+	}
+	if !handOver {
+		delete(patch, "owner")
+	}
+
+	// The rules are checked on the patched character as it would be now,
+	// the store then applies the patch to what is stored as long as the
+	// fields the rules look at have not changed in the meantime
+	patched, err := patch.Apply(old)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !checkCharacter(w, r, "", patched) {
+		return
+	}
+	expect, err := dndinterface.CharacterFields(old, characterRuleFields...)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	ch, err := db.PatchCharacter(r.Context(), id, patch, expect)
+	if err == dndinterface.ErrConflict {
+		writeError(w, http.StatusConflict, codeConflict, "The character was changed by someone else, try again")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(dndinterface.MultiCharacterGetReturn{ID: id, Character: ch})
+}
+
+// deleteCharacterV2 removes a character, the store also takes it out of its
+// campaign
+func deleteCharacterV2(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, _, ok := editCharacter(w, r, id); !ok {
+		return
+	}
+
+	if err := db.RemoveCharacter(r.Context(), id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func createUserV2(w http.ResponseWriter, r *http.Request) {
+	var user dndinterface.User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	if user.UserRole == "" {
+		user.UserRole = dndinterface.RolePlayer
+	}
+	if fields := userFieldErrors("", user); len(fields) > 0 {
+		writeInvalid(w, fields...)
+		return
+	}
+	user.UserRole = strings.ToLower(user.UserRole)
+
+	err := db.AddUser(r.Context(), user.Username, user.Password, user.UserRole)
+	if err == nil && user.Email != "" {
+		err = db.SetEmail(r.Context(), user.Username, user.Email)
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeCreated(w, apiV2+"/users/"+url.PathEscape(user.Username),
+		profileGet{Username: user.Username, UserRole: user.UserRole, Email: user.Email})
+}
+
+// getUserV2 returns the profile of a user, only admins can look at others
+func getUserV2(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	claims := requestClaims(r)
+	if name != claims.Username && !hasRole(claims, dndinterface.RoleAdmin) {
+		writeForbidden(w)
+		return
+	}
+
+	user, err := db.GetUser(r.Context(), name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(profileGet{Username: user.Username, UserRole: user.UserRole, Email: user.Email})
+}
+
+func replaceUserV2(w http.ResponseWriter, r *http.Request) {
+	var user dndinterface.User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	if fields := userFieldErrors("", user); len(fields) > 0 {
+		writeInvalid(w, fields...)
+		return
+	}
+	user.UserRole = strings.ToLower(user.UserRole)
+	name := mux.Vars(r)["name"]
+	if !canChangeUser(w, r, name, user.UserRole) {
+		return
+	}
+
+	if err := db.UpdateUser(r.Context(), user, name); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userPatchV2 is the body of a PATCH on a user, fields that are left out keep
+// their value. Users changing their own password or email confirm it with
+// CurrentPassword, admins do not need to.
+type userPatchV2 struct {
+	Username        *string `json:"Username"`
+	Password        *string `json:"Password"`
+	UserRole        *string `json:"UserRole"`
+	Email           *string `json:"email"`
+	CurrentPassword string  `json:"currentPassword"`
+}
+
+// patchUserV2 changes only the fields that are in the body. Users can change
+// their own password and email, only admins can change other users, roles and
+// usernames.
+func patchUserV2(w http.ResponseWriter, r *http.Request) {
+	var patch userPatchV2
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	claims := requestClaims(r)
+	admin := hasRole(claims, dndinterface.RoleAdmin)
+	if name != claims.Username && !admin {
+		writeForbidden(w)
+		return
+	}
+
+	user, err := db.GetUser(r.Context(), name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	user.Password = ""
+	// The role in the token can be older than the one in the store, so a
+	// demoted user could otherwise claim the old role back
+	role := user.UserRole
+	if patch.Username != nil {
+		user.Username = *patch.Username
+	}
+	if patch.Password != nil {
+		user.Password = *patch.Password
+	}
+	if patch.UserRole != nil {
+		user.UserRole = *patch.UserRole
+	}
+	if patch.Email != nil {
+		user.Email = *patch.Email
+	}
+
+	if fields := userFieldErrors("", user); len(fields) > 0 {
+		writeInvalid(w, fields...)
+		return
+	}
+	if !admin {
+		if user.Username != name || !strings.EqualFold(user.UserRole, role) {
+			writeError(w, http.StatusForbidden, codeForbidden, "Only admins can change usernames and roles")
+			return
+		}
+		if (patch.Password != nil || patch.Email != nil) && !confirmPassword(w, r, patch.CurrentPassword) {
+			return
+		}
+	}
+	user.UserRole = strings.ToLower(user.UserRole)
+	if !canChangeUser(w, r, name, user.UserRole) {
+		return
+	}
+
+	err = db.UpdateUser(r.Context(), user, name)
+	// An empty email keeps the old one in UpdateUser, so it is set on its own
+	if err == nil && patch.Email != nil {
+		err = db.SetEmail(r.Context(), user.Username, user.Email)
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	// Like on /changePassword the sessions of the user are signed out, the
+	// caller gets new tokens when it changed its own password
+	if patch.Password != nil || patch.UserRole != nil {
+		if err := db.RevokeUserRefreshTokens(r.Context(), user.Username); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if name == claims.Username {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			if _, err := issueTokens(w, r, user.Username, user.UserRole); err != nil {
+				writeStoreError(w, err)
+				return
+			}
+		}
+	}
+	json.NewEncoder(w).Encode(profileGet{Username: user.Username, UserRole: user.UserRole, Email: user.Email})
+}
+
+func deleteUserV2(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !canChangeUser(w, r, name, "") {
+		return
+	}
+
+	if err := db.DeleteUser(r.Context(), name); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
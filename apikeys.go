@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+)
+
+const apiKeyPrefix = "dnd_"
+
+// The scopes an API key can be given
+const (
+	scopeCharactersRead  = "characters:read"
+	scopeCharactersWrite = "characters:write"
+	scopeCampaignsRead   = "campaigns:read"
+)
+
+// routeScopes lists the routes API keys can be used on and the scope they
+// need. Every other route only accepts tokens from /signin.
+var routeScopes = map[string]string{
+	"/getCharacter":      scopeCharactersRead,
+	"/getMultiCharacter": scopeCharactersRead,
+	"/myCharacters":      scopeCharactersRead,
+	"/addCharacter":      scopeCharactersWrite,
+	"/updateCharacter":   scopeCharactersWrite,
+	"/getUserCampaign":   scopeCampaignsRead,
+	"/getDMCampaign":     scopeCampaignsRead,
+	"/getCampaignByName": scopeCampaignsRead,
+	"/myCampaigns":       scopeCampaignsRead,
+
+	"GET /api/v2/campaigns":                  scopeCampaignsRead,
+	"GET /api/v2/campaigns/{id}":             scopeCampaignsRead,
+	"GET /api/v2/campaigns/{id}/characters":  scopeCharactersRead,
+	"POST /api/v2/campaigns/{id}/characters": scopeCharactersWrite,
+	"GET /api/v2/characters/{id}":            scopeCharactersRead,
+	"PUT /api/v2/characters/{id}":            scopeCharactersWrite,
+	"PATCH /api/v2/characters/{id}":          scopeCharactersWrite,
+}
+
+func validScope(scope string) bool {
+	switch scope {
+	case scopeCharactersRead, scopeCharactersWrite, scopeCampaignsRead:
+		return true
+	}
+	return false
+}
+
+// scopeAllowed reports if an API key with the scopes may call the route
+func scopeAllowed(r *http.Request, scopes []string) bool {
+	key, found := routeKey(r, func(key string) bool {
+		_, found := routeScopes[key]
+		return found
+	})
+	if !found {
+		return false
+	}
+	needed := routeScopes[key]
+	for _, scope := range scopes {
+		if scope == needed {
+			return true
+		}
+	}
+	return false
+}
+
+// newAPIKey returns the key for the client, its id and the hash that is stored.
+// Keys look like dnd_<id>.<secret> so the user can be found by the id.
+func newAPIKey() (string, string, string, error) {
+	idBytes := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+
+	id := hex.EncodeToString(idBytes)
+	key := apiKeyPrefix + id + "." + base64.RawURLEncoding.EncodeToString(secret)
+	return key, id, hashToken(key), nil
+}
+
+// apiKeyClaims looks up the owner of an API key and returns claims for it.
+// The role is read from the user on every request.
+func apiKeyClaims(r *http.Request, key string) (*Claims, error) {
+	parts := strings.SplitN(strings.TrimPrefix(key, apiKeyPrefix), ".", 2)
+	if !strings.HasPrefix(key, apiKeyPrefix) || len(parts) != 2 {
+		return nil, dndinterface.ErrInvalidCredentials
+	}
+
+	user, err := db.FindAPIKey(r.Context(), parts[0])
+	if err == dndinterface.ErrNotFound {
+		return nil, dndinterface.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hash := hashToken(key)
+	for _, stored := range user.APIKeys {
+		if stored.ID == parts[0] && subtle.ConstantTimeCompare([]byte(stored.Hash), []byte(hash)) == 1 {
+			return &Claims{
+				Username: user.Username,
+				Type:     user.UserRole,
+				APIKeyID: stored.ID,
+				Scopes:   stored.Scopes,
+			}, nil
+		}
+	}
+	return nil, dndinterface.ErrInvalidCredentials
+}
+
+type createAPIKeyPost struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// apiKeyGet is an API key without its hash
+type apiKeyGet struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// createAPIKeyReturn holds the key itself, it is only shown this once
+type createAPIKeyReturn struct {
+	apiKeyGet
+	Key string `json:"key"`
+}
+
+// createAPIKey creates an API key for the logged in user
+func createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var postData createAPIKeyPost
+	err := json.NewDecoder(r.Body).Decode(&postData)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	if postData.Name == "" {
+		writeInvalid(w, fieldError{Field: "name", Message: "Must not be empty"})
+		return
+	}
+	if len(postData.Scopes) == 0 {
+		writeInvalid(w, fieldError{Field: "scopes", Message: "Must have at least one scope"})
+		return
+	}
+	for _, scope := range postData.Scopes {
+		if !validScope(scope) {
+			writeInvalid(w, fieldError{Field: "scopes", Message: "Unknown scope " + scope})
+			return
+		}
+	}
+
+	key, id, hash, err := newAPIKey()
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	stored := dndinterface.APIKey{
+		ID:        id,
+		Name:      postData.Name,
+		Hash:      hash,
+		Scopes:    postData.Scopes,
+		CreatedAt: time.Now(),
+	}
+	if err := db.AddAPIKey(r.Context(), requestClaims(r).Username, stored); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createAPIKeyReturn{
+		apiKeyGet: apiKeyGet{ID: id, Name: stored.Name, Scopes: stored.Scopes, CreatedAt: stored.CreatedAt},
+		Key:       key,
+	})
+}
+
+// getAPIKeys lists the API keys of the logged in user, admins can pass a
+// username in the query
+func getAPIKeys(w http.ResponseWriter, r *http.Request) {
+	user, err := db.GetUser(r.Context(), targetUsername(r, r.URL.Query().Get("username")))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	keys := []apiKeyGet{}
+	for _, key := range user.APIKeys {
+		keys = append(keys, apiKeyGet{ID: key.ID, Name: key.Name, Scopes: key.Scopes, CreatedAt: key.CreatedAt})
+	}
+	json.NewEncoder(w).Encode(keys)
+}
+
+type revokeAPIKeyPost struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// revokeAPIKey removes an API key of the logged in user, admins can revoke
+// the keys of anyone
+func revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	var postData revokeAPIKeyPost
+	err := json.NewDecoder(r.Body).Decode(&postData)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	err = db.RemoveAPIKey(r.Context(), targetUsername(r, postData.Username), postData.ID)
+	if err != nil {
+		writeStoreError(w, err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	useTestStore(t)
+	tokens := addTestUsers(t, map[string]string{"p": dndinterface.RolePlayer})
+	router := newRouter()
+
+	w := serve(router, http.MethodPost, "/createAPIKey", tokens["p"], `{"name": "script", "scopes": ["`+scopeCharactersRead+`"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("createAPIKey status = %d: %s", w.Code, w.Body)
+	}
+	var created createAPIKeyReturn
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	withKey := func(key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/myCharacters", nil)
+		r.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := withKey(created.Key); w.Code != http.StatusOK {
+		t.Fatalf("status with a valid key = %d: %s", w.Code, w.Body)
+	}
+
+	revoked := serve(router, http.MethodPost, "/revokeAPIKey", tokens["p"], `{"id": "`+created.ID+`"}`)
+	if revoked.Code != http.StatusOK {
+		t.Fatalf("revokeAPIKey status = %d: %s", revoked.Code, revoked.Body)
+	}
+
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{"revoked", created.Key},
+		{"malformed", "not-a-key"},
+		{"unknown", apiKeyPrefix + "unknown.secret"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := withKey(test.key)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+			if !strings.Contains(w.Body.String(), "API key") {
+				t.Errorf("body = %s, want a message about the API key", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+	"github.com/gorilla/mux"
+)
+
+type contextKey int
+
+const claimsKey contextKey = iota
+
+// routePolicies lists the roles allowed to call a route. Keys are path
+// templates, prefixed with the method for routes that share a path. Routes
+// that are not listed are open to every logged in user.
+var routePolicies = map[string][]string{
+	"/addUser":           {dndinterface.RoleAdmin},
+	"/getUserList":       {dndinterface.RoleAdmin},
+	"/deleteUser":        {dndinterface.RoleAdmin},
+	"/updateUser":        {dndinterface.RoleAdmin},
+	"/addCampaign":       {dndinterface.RoleAdmin, dndinterface.RoleDM},
+	"/updateCampaign":    {dndinterface.RoleAdmin, dndinterface.RoleDM},
+	"/deleteCampaign":    {dndinterface.RoleAdmin, dndinterface.RoleDM},
+	"/getAllCampaigns":   {dndinterface.RoleAdmin},
+	"/signoutEverywhere": {dndinterface.RoleAdmin},
+	"/lockouts":          {dndinterface.RoleAdmin},
+	"/createInvite":      {dndinterface.RoleAdmin, dndinterface.RoleDM},
+	"/clearLockout":      {dndinterface.RoleAdmin},
+
+	"POST /api/v2/campaigns":        {dndinterface.RoleAdmin, dndinterface.RoleDM},
+	"PUT /api/v2/campaigns/{id}":    {dndinterface.RoleAdmin, dndinterface.RoleDM},
+	"PATCH /api/v2/campaigns/{id}":  {dndinterface.RoleAdmin, dndinterface.RoleDM},
+	"DELETE /api/v2/campaigns/{id}": {dndinterface.RoleAdmin, dndinterface.RoleDM},
+	"GET /api/v2/users":             {dndinterface.RoleAdmin},
+	"POST /api/v2/users":            {dndinterface.RoleAdmin},
+	"PUT /api/v2/users/{name}":      {dndinterface.RoleAdmin},
+	"DELETE /api/v2/users/{name}":   {dndinterface.RoleAdmin},
+}
+
+// hasRole reports if the role in the claims is one of roles
+func hasRole(claims *Claims, roles ...string) bool {
+	for _, role := range roles {
+		if strings.EqualFold(claims.Type, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// routeKey finds the key for the matched route in a route table, trying the
+// method and path template before the path template alone
+func routeKey(r *http.Request, keys func(string) bool) (string, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "", false
+	}
+	path, err := route.GetPathTemplate()
+	if err != nil {
+		return "", false
+	}
+
+	for _, key := range []string{r.Method + " " + path, path} {
+		if keys(key) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// routeAllowed checks the claims against the policy of the matched route.
+// Requests made with an API key also need a scope for the route.
+func routeAllowed(r *http.Request, claims *Claims) bool {
+	if mux.CurrentRoute(r) == nil {
+		return false
+	}
+	if claims.APIKeyID != "" && !scopeAllowed(r, claims.Scopes) {
+		return false
+	}
+
+	key, found := routeKey(r, func(key string) bool {
+		_, found := routePolicies[key]
+		return found
+	})
+	return !found || hasRole(claims, routePolicies[key]...)
+}
+
+func withClaims(r *http.Request, claims *Claims) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), claimsKey, claims))
+}
+
+// requestClaims returns the claims of the token the request was authorized with
+func requestClaims(r *http.Request) *Claims {
+	claims, _ := r.Context().Value(claimsKey).(*Claims)
+	return claims
+}
+
+// canManageCampaign reports if the caller is an admin or the DM of the campaign
+func canManageCampaign(r *http.Request, campaign dndinterface.Campaign) bool {
+	claims := requestClaims(r)
+	if claims == nil {
+		return false
+	}
+	return hasRole(claims, dndinterface.RoleAdmin) || campaign.DM == claims.Username
+}
+
+// findCampaign looks a campaign up by its ID, or by its name for clients of
+// the v1 routes that still send names. When more than one DM uses the name
+// the campaign username runs or plays in is taken, if that is still not
+// clear ErrConflict is returned.
+func findCampaign(ctx context.Context, ref, username string) (dndinterface.Campaign, error) {
+	campaign, err := db.GetCampaignByID(ctx, ref)
+	if err != dndinterface.ErrNotFound && err != dndinterface.ErrInvalidID {
+		return campaign, err
+	}
+
+	campaigns, err := db.GetCampaignsByName(ctx, ref)
+	if err != nil {
+		return dndinterface.Campaign{}, err
+	}
+	switch len(campaigns) {
+	case 0:
+		return dndinterface.Campaign{}, dndinterface.ErrNotFound
+	case 1:
+		return campaigns[0], nil
+	}
+
+	var joined []dndinterface.Campaign
+	for _, campaign := range campaigns {
+		if campaign.DM == username || campaign.HasPlayer(username) {
+			joined = append(joined, campaign)
+		}
+	}
+	if len(joined) == 1 {
+		return joined[0], nil
+	}
+	return dndinterface.Campaign{}, dndinterface.ErrConflict
+}
+
+// authorizeCampaign loads the campaign and writes an error response unless
+// the caller may manage it
+func authorizeCampaign(w http.ResponseWriter, r *http.Request, ref string) (dndinterface.Campaign, bool) {
+	campaign, err := findCampaign(r.Context(), ref, requestClaims(r).Username)
+	if err != nil {
+		writeStoreError(w, err)
+		return dndinterface.Campaign{}, false
+	}
+
+	if !canManageCampaign(r, campaign) {
+		writeForbidden(w)
+		return dndinterface.Campaign{}, false
+	}
+	return campaign, true
+}
+
+// canChangeDM writes the error response unless the caller may give campaign
+// to dm. Only admins can hand a campaign over to another DM.
+func canChangeDM(w http.ResponseWriter, r *http.Request, campaign dndinterface.Campaign, dm string) bool {
+	if dm == campaign.DM || hasRole(requestClaims(r), dndinterface.RoleAdmin) {
+		return true
+	}
+	writeError(w, http.StatusForbidden, codeForbidden, "Only admins can hand a campaign over to another DM")
+	return false
+}
+
+// isCampaignMember reports if the caller is an admin, the DM or one of the players
+func isCampaignMember(r *http.Request, campaign dndinterface.Campaign) bool {
+	claims := requestClaims(r)
+	if claims == nil {
+		return false
+	}
+	return canManageCampaign(r, campaign) || campaign.HasPlayer(claims.Username)
+}
+
+// canEditCharacter reports if the caller owns the character or manages its
+// campaign. Characters created before owners were recorded can only be edited
+// by the DM and admins.
+func canEditCharacter(r *http.Request, campaign dndinterface.Campaign, ch dndinterface.Character) bool {
+	claims := requestClaims(r)
+	if claims == nil {
+		return false
+	}
+	return (ch.Owner != "" && ch.Owner == claims.Username) || canManageCampaign(r, campaign)
+}
+
+// canViewCharacter reports if the caller owns the character or takes part in its campaign
+func canViewCharacter(r *http.Request, campaign dndinterface.Campaign, ch dndinterface.Character) bool {
+	claims := requestClaims(r)
+	if claims == nil {
+		return false
+	}
+	return ch.Owner == claims.Username || isCampaignMember(r, campaign)
+}
+
+// characterCampaign gets the campaign of a character, characters that do not
+// belong to any campaign get an empty campaign
+func characterCampaign(r *http.Request, id string) (dndinterface.Campaign, error) {
+	campaign, err := db.GetCharacterCampaign(r.Context(), id)
+	if err == dndinterface.ErrNotFound {
+		return dndinterface.Campaign{}, nil
+	}
+	return campaign, err
+}
+
+// targetUsername returns the user a request is about. Admins may ask about
+// anyone, everybody else always gets their own username from the token.
+func targetUsername(r *http.Request, requested string) string {
+	claims := requestClaims(r)
+	if requested != "" && hasRole(claims, dndinterface.RoleAdmin) {
+		return requested
+	}
+	return claims.Username
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+)
+
+// addTestUsers adds a user for every name and role and returns their tokens
+func addTestUsers(t *testing.T, roles map[string]string) map[string]string {
+	t.Helper()
+	tokens := map[string]string{}
+	for name, role := range roles {
+		if err := db.AddUser(context.Background(), name, name+"-password", role); err != nil {
+			t.Fatal(err)
+		}
+		claims := testClaims(time.Now())
+		claims.Username = name
+		claims.Type = role
+		token, err := jwtKeys.sign(jwt.GetSigningMethod(conf.JWTAlgorithm), claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[name] = token
+	}
+	return tokens
+}
+
+// serve sends a request through the router with the token of a user
+func serve(router http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+// forbiddenByPolicy reports if the request was turned down by routeAllowed
+// and not by the handler
+func forbiddenByPolicy(w *httptest.ResponseRecorder) bool {
+	return w.Code == http.StatusForbidden && strings.Contains(w.Body.String(), "You are not allowed to do this")
+}
+
+func TestRoutePolicies(t *testing.T) {
+	useTestStore(t)
+	roles := map[string]string{
+		"admin": dndinterface.RoleAdmin,
+		"dm":    dndinterface.RoleDM,
+		"p":     dndinterface.RolePlayer,
+	}
+	tokens := addTestUsers(t, roles)
+	router := newRouter()
+
+	// Bodies for handlers that would turn the caller down on their own
+	bodies := map[string]string{
+		"/createInvite": `{"userRole": "player", "campaign": "none"}`,
+	}
+
+	// Every route that takes a token is called by every role. Roles that are
+	// not in the policy of the route must be turned down, all others get
+	// through to the handler.
+	checked := map[string]bool{}
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// The prefix of the v2 subrouter
+			return nil
+		}
+
+		for _, method := range methods {
+			if method == http.MethodOptions {
+				continue
+			}
+			key := method + " " + path
+			policy, found := routePolicies[key]
+			if !found {
+				key = path
+				policy, found = routePolicies[key]
+			}
+			checked[key] = true
+
+			for user, role := range roles {
+				t.Run(method+" "+path+" as "+user, func(t *testing.T) {
+					body, ok := bodies[path]
+					if !ok {
+						body = "{}"
+					}
+					// Users are asked about themselves, which they may see
+					target := strings.NewReplacer("{id}", "000000000000000000000000", "{name}", user).Replace(path)
+					w := serve(router, method, target, tokens[user], body)
+					allowed := !found || hasRole(&Claims{Type: role}, policy...)
+					if allowed && forbiddenByPolicy(w) {
+						t.Errorf("status = %d, want the request to reach the handler: %s", w.Code, w.Body)
+					}
+					if !allowed && w.Code != http.StatusForbidden {
+						t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+					}
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key := range routePolicies {
+		if !checked[key] {
+			t.Errorf("policy %q does not match any route", key)
+		}
+	}
+}
+
+func TestCharacterAccess(t *testing.T) {
+	useTestStore(t)
+	ctx := context.Background()
+	tokens := addTestUsers(t, map[string]string{
+		"admin":    dndinterface.RoleAdmin,
+		"dm":       dndinterface.RoleDM,
+		"owner":    dndinterface.RolePlayer,
+		"player":   dndinterface.RolePlayer,
+		"outsider": dndinterface.RolePlayer,
+	})
+	campaignID, err := db.AddCampain(ctx, dndinterface.Campaign{Name: "c", DM: "dm", Players: []string{"owner", "player"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := dndinterface.Character{CharacterName: "Vex", Level: 1}
+	ch.Stats = dndinterface.Stats{Strength: 10, Dexterity: 10, Constitution: 10, Intelligence: 10, Wisdom: 10, Charisma: 10}
+	unowned, err := db.AddCharacter(ctx, campaignID, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.Owner = "owner"
+	owned, err := db.AddCharacter(ctx, campaignID, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user      string
+		character string
+		view      int
+		edit      int
+	}{
+		{"owner", owned, http.StatusOK, http.StatusOK},
+		{"dm", owned, http.StatusOK, http.StatusOK},
+		{"admin", owned, http.StatusOK, http.StatusOK},
+		{"player", owned, http.StatusOK, http.StatusForbidden},
+		{"outsider", owned, http.StatusForbidden, http.StatusForbidden},
+
+		// Characters without an owner can only be edited by the DM and admins
+		{"owner", unowned, http.StatusOK, http.StatusForbidden},
+		{"dm", unowned, http.StatusOK, http.StatusOK},
+		{"admin", unowned, http.StatusOK, http.StatusOK},
+		{"player", unowned, http.StatusOK, http.StatusForbidden},
+		{"outsider", unowned, http.StatusForbidden, http.StatusForbidden},
+	}
+
+	router := newRouter()
+	for _, test := range tests {
+		name := test.user + " on the owned character"
+		if test.character == unowned {
+			name = test.user + " on the unowned character"
+		}
+		t.Run(name, func(t *testing.T) {
+			path := "/api/v2/characters/" + test.character
+			if w := serve(router, http.MethodGet, path, tokens[test.user], ""); w.Code != test.view {
+				t.Errorf("GET status = %d, want %d: %s", w.Code, test.view, w.Body)
+			}
+			if w := serve(router, http.MethodPatch, path, tokens[test.user], `{"level": 2}`); w.Code != test.edit {
+				t.Errorf("PATCH status = %d, want %d: %s", w.Code, test.edit, w.Body)
+			}
+		})
+	}
+}
+
+func TestCampaignDMChange(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		user   string
+		method string
+		path   string
+		body   string
+		status int
+		dm     string
+	}{
+		{"DM patches the DM", "dm", http.MethodPatch, "/api/v2/campaigns/{id}", `{"DM": "dm2"}`, http.StatusForbidden, "dm"},
+		{"DM replaces the DM", "dm", http.MethodPut, "/api/v2/campaigns/{id}", `{"Name": "c", "DM": "dm2"}`, http.StatusForbidden, "dm"},
+		{"DM updates the DM", "dm", http.MethodPost, "/updateCampaign", `{"name": "c", "campaign": {"Name": "c", "DM": "dm2"}}`, http.StatusForbidden, "dm"},
+		{"DM keeps the DM", "dm", http.MethodPatch, "/api/v2/campaigns/{id}", `{"Name": "d"}`, http.StatusOK, "dm"},
+		{"admin patches the DM", "admin", http.MethodPatch, "/api/v2/campaigns/{id}", `{"DM": "dm2"}`, http.StatusOK, "dm2"},
+		{"admin updates the DM", "admin", http.MethodPost, "/updateCampaign", `{"name": "c", "campaign": {"Name": "c", "DM": "dm2"}}`, http.StatusOK, "dm2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestStore(t)
+			tokens := addTestUsers(t, map[string]string{
+				"admin": dndinterface.RoleAdmin,
+				"dm":    dndinterface.RoleDM,
+				"dm2":   dndinterface.RoleDM,
+			})
+			campaignID, err := db.AddCampain(ctx, dndinterface.Campaign{Name: "c", DM: "dm"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path := strings.Replace(test.path, "{id}", campaignID, 1)
+			w := serve(newRouter(), test.method, path, tokens[test.user], test.body)
+			if w.Code != test.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+
+			campaign, err := db.GetCampaignByID(ctx, campaignID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if campaign.DM != test.dm {
+				t.Errorf("DM = %q, want %q", campaign.DM, test.dm)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+)
+
+// bootstrapAdmin creates the first admin when there are no users at all, so
+// a new install can be logged in to. Without a configured password a random
+// one is made and printed once.
+func bootstrapAdmin(cfg AdminConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	users, err := db.GetAllUsers(ctx)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return nil
+	}
+
+	password := cfg.Password
+	generated := password == ""
+	if generated {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return err
+		}
+		password = base64.RawURLEncoding.EncodeToString(buf)
+	}
+
+	if err := db.AddUser(ctx, cfg.Username, password, dndinterface.RoleAdmin); err != nil {
+		return fmt.Errorf("could not create admin %s: %v", cfg.Username, err)
+	}
+
+	if generated {
+		fmt.Printf("Created admin %q with password %q, change it after signing in\n", cfg.Username, password)
+	} else {
+		fmt.Printf("Created admin %q\n", cfg.Username)
+	}
+	return nil
+}
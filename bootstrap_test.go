@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+)
+
+func TestBootstrapAdmin(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("empty database", func(t *testing.T) {
+		useTestStore(t)
+		if err := bootstrapAdmin(AdminConfig{Username: "root", Password: "first-password"}); err != nil {
+			t.Fatal(err)
+		}
+		if role, err := db.CheckUser(ctx, "root", "first-password"); err != nil || role != dndinterface.RoleAdmin {
+			t.Errorf("CheckUser() = %q, %v, want %q", role, err, dndinterface.RoleAdmin)
+		}
+	})
+
+	t.Run("generated password", func(t *testing.T) {
+		useTestStore(t)
+		if err := bootstrapAdmin(AdminConfig{Username: "root"}); err != nil {
+			t.Fatal(err)
+		}
+		user, err := db.GetUser(ctx, "root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.UserRole != dndinterface.RoleAdmin {
+			t.Errorf("role = %q, want %q", user.UserRole, dndinterface.RoleAdmin)
+		}
+		if _, err := db.CheckUser(ctx, "root", ""); err != dndinterface.ErrInvalidCredentials {
+			t.Errorf("CheckUser() with an empty password error = %v, want ErrInvalidCredentials", err)
+		}
+	})
+
+	t.Run("existing users", func(t *testing.T) {
+		useTestStore(t)
+		addTestUsers(t, map[string]string{"p": dndinterface.RolePlayer})
+		if err := bootstrapAdmin(AdminConfig{Username: "root", Password: "first-password"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.GetUser(ctx, "root"); err != dndinterface.ErrNotFound {
+			t.Errorf("GetUser() error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("second start", func(t *testing.T) {
+		useTestStore(t)
+		if err := bootstrapAdmin(AdminConfig{Username: "root", Password: "first-password"}); err != nil {
+			t.Fatal(err)
+		}
+
+		// A changed configuration does not touch the admin that exists
+		if err := bootstrapAdmin(AdminConfig{Username: "root", Password: "second-password"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := bootstrapAdmin(AdminConfig{Username: "other", Password: "second-password"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.CheckUser(ctx, "root", "first-password"); err != nil {
+			t.Errorf("CheckUser() with the first password error = %v, want none", err)
+		}
+		if _, err := db.CheckUser(ctx, "root", "second-password"); err != dndinterface.ErrInvalidCredentials {
+			t.Errorf("CheckUser() with the second password error = %v, want ErrInvalidCredentials", err)
+		}
+		if _, err := db.GetUser(ctx, "other"); err != dndinterface.ErrNotFound {
+			t.Errorf("GetUser() error = %v, want ErrNotFound", err)
+		}
+	})
+}
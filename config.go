@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+)
+
+// Config holds the server settings. It is read from the optional JSON file
+// named by the ConfigFile env var, after which env vars override single values.
+type Config struct {
+	DBBackend string                   `json:"dbBackend"`
+	DBPath    string                   `json:"dbPath"`
+	Mongo     dndinterface.MongoConfig `json:"mongo"`
+
+	AccessTokenTTL  dndinterface.Duration `json:"accessTokenTTL"`
+	RefreshTokenTTL dndinterface.Duration `json:"refreshTokenTTL"`
+	InviteTTL       dndinterface.Duration `json:"inviteTTL"`
+
+	PasswordResetTTL dndinterface.Duration `json:"passwordResetTTL"`
+	PasswordResetURL string                `json:"passwordResetURL"`
+
+	JWTKey       string                `json:"jwtKey"`
+	JWTKeys      []SigningKey          `json:"jwtKeys"`
+	JWTAlgorithm string                `json:"jwtAlgorithm"`
+	JWTIssuer    string                `json:"jwtIssuer"`
+	JWTAudience  string                `json:"jwtAudience"`
+	JWTLeeway    dndinterface.Duration `json:"jwtLeeway"`
+
+	Cookie CookieConfig `json:"cookie"`
+
+	Login LoginLimitConfig `json:"login"`
+
+	Notifier string     `json:"notifier"`
+	SMTP     SMTPConfig `json:"smtp"`
+
+	Admin AdminConfig `json:"admin"`
+}
+
+// AdminConfig names the admin that is created when the database has no
+// users. Leave the password empty to have one generated and printed.
+type AdminConfig struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// SMTPConfig is used by the smtp notifier to send email
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     uint64 `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+}
+
+// LoginLimitConfig sets how failed logins are throttled. Each failure blocks
+// the username and IP for BaseDelay, doubled for every further failure up to
+// MaxDelay. Reaching a lockout threshold blocks for LockoutDuration instead,
+// a threshold of 0 turns the lockout off. Failures are forgotten ResetAfter
+// the last one, or when a lockout ends. Requests for password resets are
+// throttled with the same settings, each of them counts as a failure.
+type LoginLimitConfig struct {
+	BaseDelay            dndinterface.Duration `json:"baseDelay"`
+	MaxDelay             dndinterface.Duration `json:"maxDelay"`
+	UserLockoutThreshold uint64                `json:"userLockoutThreshold"`
+	IPLockoutThreshold   uint64                `json:"ipLockoutThreshold"`
+	LockoutDuration      dndinterface.Duration `json:"lockoutDuration"`
+	ResetAfter           dndinterface.Duration `json:"resetAfter"`
+}
+
+// SigningKey is a secret for signing access tokens. Its ID is written to the
+// kid header of every token, so tokens signed with a key stay valid for as
+// long as the key is listed. The last key in JWTKeys signs new tokens.
+type SigningKey struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// defaultKeyID is used for JWTKey, tokens without a kid header were signed with it
+const defaultKeyID = "default"
+
+// signingKeys returns all keys from the oldest to the newest. JWTKey counts
+// as the oldest key so it can be phased out by adding keys to JWTKeys.
+func (c Config) signingKeys() []SigningKey {
+	var keys []SigningKey
+	if c.JWTKey != "" {
+		keys = append(keys, SigningKey{ID: defaultKeyID, Secret: c.JWTKey})
+	}
+	return append(keys, c.JWTKeys...)
+}
+
+// CookieConfig sets the attributes of the cookies holding the tokens. Secure
+// has to be turned off when running the server over plain http locally.
+type CookieConfig struct {
+	Secure   bool   `json:"secure"`
+	SameSite string `json:"sameSite"`
+	Path     string `json:"path"`
+	Domain   string `json:"domain"`
+}
+
+// jwtAlgorithms are the signing methods that can be configured
+var jwtAlgorithms = map[string]bool{"HS256": true, "HS384": true, "HS512": true}
+
+// sameSiteMode parses the SameSite setting
+func (c CookieConfig) sameSiteMode() (http.SameSite, error) {
+	switch strings.ToLower(c.SameSite) {
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return http.SameSiteDefaultMode, fmt.Errorf("unknown cookie SameSite mode %q", c.SameSite)
+}
+
+func defaultConfig() Config {
+	return Config{
+		DBBackend: "mongo",
+		DBPath:    "dnd.db",
+		Mongo:     dndinterface.DefaultMongoConfig(),
+
+		AccessTokenTTL:  dndinterface.Duration(15 * time.Minute),
+		RefreshTokenTTL: dndinterface.Duration(30 * 24 * time.Hour),
+		InviteTTL:       dndinterface.Duration(7 * 24 * time.Hour),
+
+		PasswordResetTTL: dndinterface.Duration(time.Hour),
+
+		JWTAlgorithm: "HS256",
+		JWTIssuer:    "dndbackend",
+		JWTAudience:  "dndbackend",
+		JWTLeeway:    dndinterface.Duration(30 * time.Second),
+
+		Cookie: CookieConfig{
+			Secure:   true,
+			SameSite: "lax",
+			Path:     "/",
+		},
+
+		Login: LoginLimitConfig{
+			BaseDelay:            dndinterface.Duration(time.Second),
+			MaxDelay:             dndinterface.Duration(time.Minute),
+			UserLockoutThreshold: 10,
+			IPLockoutThreshold:   50,
+			LockoutDuration:      dndinterface.Duration(15 * time.Minute),
+			ResetAfter:           dndinterface.Duration(time.Hour),
+		},
+
+		Notifier: "log",
+		SMTP:     SMTPConfig{Port: 587},
+
+		Admin: AdminConfig{Username: "admin"},
+	}
+}
+
+func (c Config) validate() error {
+	switch {
+	case len(c.signingKeys()) == 0:
+		return fmt.Errorf("JWTKey or JWTKeys must be set")
+	case !jwtAlgorithms[c.JWTAlgorithm]:
+		return fmt.Errorf("unsupported jwt algorithm %q", c.JWTAlgorithm)
+	case c.JWTIssuer == "" || c.JWTAudience == "":
+		return fmt.Errorf("jwt issuer and audience must be set")
+	case c.JWTLeeway < 0:
+		return fmt.Errorf("jwt leeway can not be negative")
+	case c.AccessTokenTTL <= 0 || c.RefreshTokenTTL <= 0 || c.InviteTTL <= 0 || c.PasswordResetTTL <= 0:
+		return fmt.Errorf("token and invite lifetimes must be positive")
+	case c.Login.BaseDelay < 0 || c.Login.MaxDelay < c.Login.BaseDelay:
+		return fmt.Errorf("login max delay must be at least the base delay")
+	case c.Login.LockoutDuration < 0 || c.Login.ResetAfter < 0:
+		return fmt.Errorf("login lockout durations can not be negative")
+	case c.Notifier == "smtp" && (c.SMTP.Host == "" || c.SMTP.From == ""):
+		return fmt.Errorf("the smtp notifier needs a host and a from address")
+	case c.Admin.Username == "":
+		return fmt.Errorf("the admin username can not be empty")
+	}
+
+	ids := map[string]bool{}
+	for _, key := range c.signingKeys() {
+		switch {
+		case key.ID == "" || key.Secret == "":
+			return fmt.Errorf("jwt keys need an id and a secret")
+		case ids[key.ID]:
+			return fmt.Errorf("jwt key id %q is used twice", key.ID)
+		}
+		ids[key.ID] = true
+	}
+
+	mode, err := c.Cookie.sameSiteMode()
+	if err != nil {
+		return err
+	}
+	if mode == http.SameSiteNoneMode && !c.Cookie.Secure {
+		return fmt.Errorf("cookies with SameSite none must be secure")
+	}
+	return nil
+}
+
+func loadConfig() (Config, error) {
+	cfg := defaultConfig()
+
+	if path := os.Getenv("ConfigFile"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return cfg, fmt.Errorf("could not open config file: %v", err)
+		}
+		defer f.Close()
+
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cfg); err != nil {
+			return cfg, fmt.Errorf("could not parse config file %s: %v", path, err)
+		}
+	}
+
+	envString("DBBackend", &cfg.DBBackend)
+	envString("DBPath", &cfg.DBPath)
+
+	envString("MongoURI", &cfg.Mongo.URI)
+	envString("MongoDatabase", &cfg.Mongo.Database)
+	envString("MongoAuthSource", &cfg.Mongo.AuthSource)
+	envString("MongoUser", &cfg.Mongo.Username)
+	envString("MongoPassword", &cfg.Mongo.Password)
+	envString("MongoUsersCollection", &cfg.Mongo.UsersCollection)
+	envString("MongoCampaignsCollection", &cfg.Mongo.CampaignsCollection)
+	envString("MongoCharactersCollection", &cfg.Mongo.CharactersCollection)
+	envString("MongoRefreshTokensCollection", &cfg.Mongo.RefreshTokensCollection)
+	envString("MongoInvitesCollection", &cfg.Mongo.InvitesCollection)
+	envString("MongoPasswordResetsCollection", &cfg.Mongo.PasswordResetsCollection)
+	envString("MongoTLSCAFile", &cfg.Mongo.TLSCAFile)
+	envString("JWTKey", &cfg.JWTKey)
+	envString("JWTAlgorithm", &cfg.JWTAlgorithm)
+	envString("JWTIssuer", &cfg.JWTIssuer)
+	envString("JWTAudience", &cfg.JWTAudience)
+	envString("CookieSameSite", &cfg.Cookie.SameSite)
+	envString("CookiePath", &cfg.Cookie.Path)
+	envString("CookieDomain", &cfg.Cookie.Domain)
+	envString("PasswordResetURL", &cfg.PasswordResetURL)
+	envString("Notifier", &cfg.Notifier)
+	envString("SMTPHost", &cfg.SMTP.Host)
+	envString("SMTPUser", &cfg.SMTP.Username)
+	envString("SMTPPassword", &cfg.SMTP.Password)
+	envString("SMTPFrom", &cfg.SMTP.From)
+	envString("AdminUsername", &cfg.Admin.Username)
+	envString("AdminPassword", &cfg.Admin.Password)
+
+	errs := []error{
+		envBool("MongoTLS", &cfg.Mongo.TLS),
+		envBool("MongoTLSInsecureSkipVerify", &cfg.Mongo.TLSInsecureSkipVerify),
+		envDuration("MongoConnectTimeout", &cfg.Mongo.ConnectTimeout),
+		envDuration("MongoServerSelectionTimeout", &cfg.Mongo.ServerSelectionTimeout),
+		envDuration("MongoOperationTimeout", &cfg.Mongo.OperationTimeout),
+		envUint("MongoMaxPoolSize", &cfg.Mongo.MaxPoolSize),
+		envUint("MongoMinPoolSize", &cfg.Mongo.MinPoolSize),
+		envDuration("AccessTokenTTL", &cfg.AccessTokenTTL),
+		envDuration("RefreshTokenTTL", &cfg.RefreshTokenTTL),
+		envDuration("InviteTTL", &cfg.InviteTTL),
+		envDuration("PasswordResetTTL", &cfg.PasswordResetTTL),
+		envUint("SMTPPort", &cfg.SMTP.Port),
+		envDuration("JWTLeeway", &cfg.JWTLeeway),
+		envBool("CookieSecure", &cfg.Cookie.Secure),
+		envKeys("JWTKeys", &cfg.JWTKeys),
+		envDuration("LoginBaseDelay", &cfg.Login.BaseDelay),
+		envDuration("LoginMaxDelay", &cfg.Login.MaxDelay),
+		envUint("LoginUserLockoutThreshold", &cfg.Login.UserLockoutThreshold),
+		envUint("LoginIPLockoutThreshold", &cfg.Login.IPLockoutThreshold),
+		envDuration("LoginLockoutDuration", &cfg.Login.LockoutDuration),
+		envDuration("LoginResetAfter", &cfg.Login.ResetAfter),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return cfg, err
+		}
+	}
+
+	return cfg, cfg.validate()
+}
+
+func envString(name string, dst *string) {
+	if v, ok := os.LookupEnv(name); ok {
+		*dst = v
+	}
+}
+
+func envBool(name string, dst *bool) error {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("env %s: %v", name, err)
+	}
+	*dst = b
+	return nil
+}
+
+func envUint(name string, dst *uint64) error {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return fmt.Errorf("env %s: %v", name, err)
+	}
+	*dst = n
+	return nil
+}
+
+// envKeys reads signing keys written as id:secret separated by commas
+func envKeys(name string, dst *[]SigningKey) error {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	var keys []SigningKey
+	for _, entry := range strings.Split(v, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("env %s: keys must be written as id:secret", name)
+		}
+		keys = append(keys, SigningKey{ID: parts[0], Secret: parts[1]})
+	}
+	*dst = keys
+	return nil
+}
+
+func envDuration(name string, dst *dndinterface.Duration) error {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("env %s: %v", name, err)
+	}
+	*dst = dndinterface.Duration(d)
+	return nil
+}
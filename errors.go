@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// The codes used in error responses, clients should check these and not the
+// messages
+const (
+	codeBadRequest      = "bad_request"
+	codeUnauthorized    = "unauthorized"
+	codeForbidden       = "forbidden"
+	codeNotFound        = "not_found"
+	codeAlreadyExists   = "already_exists"
+	codeConflict        = "conflict"
+	codeInvalid         = "invalid"
+	codeInvalidID       = "invalid_id"
+	codeWeakPassword    = "weak_password"
+	codeTooManyRequests = "too_many_requests"
+	codeUnsupportedType = "unsupported_media_type"
+	codeTimeout         = "timeout"
+	codeInternal        = "internal"
+)
+
+// errorResponse is the body of every error response
+type errorResponse struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Fields    []fieldError `json:"fields,omitempty"`
+	RequestID string       `json:"requestId,omitempty"`
+}
+
+// fieldError tells what is wrong with one field of the request body
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// writeError writes an error response. The request ID is taken from the
+// response header set by withRequestID.
+func writeError(w http.ResponseWriter, status int, code, message string, fields ...fieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{
+		Code:      code,
+		Message:   message,
+		Fields:    fields,
+		RequestID: w.Header().Get(requestIDHeader),
+	})
+}
+
+// writeBadRequest answers a request with a body that could not be decoded
+func writeBadRequest(w http.ResponseWriter, err error) {
+	writeError(w, http.StatusBadRequest, codeBadRequest, "The request body could not be read: "+err.Error())
+}
+
+// writeForbidden answers a request the caller is not allowed to make
+func writeForbidden(w http.ResponseWriter) {
+	writeError(w, http.StatusForbidden, codeForbidden, "You are not allowed to do this")
+}
+
+// writeInvalid answers a request with fields that did not pass validation
+func writeInvalid(w http.ResponseWriter, fields ...fieldError) {
+	writeError(w, http.StatusUnprocessableEntity, codeInvalid, "The request has invalid fields", fields...)
+}
+
+// validRequestID reports if a request ID sent by the client can be used.
+// Only short IDs of letters, digits, dashes, dots and underscores are kept
+// so they are safe to log and echo back.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '.', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID gives every request an ID, either the one the client sent or
+// a new one. It is sent back in the X-Request-ID header and in error bodies.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			b := make([]byte, 8)
+			if _, err := rand.Read(b); err != nil {
+				fmt.Println("Could not create request ID:", err)
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
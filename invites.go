@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+)
+
+type createInvitePost struct {
+	UserRole string `json:"userRole"`
+	Campaign string `json:"campaign"`
+}
+
+// createInviteReturn holds the invite code, it is only shown this once
+type createInviteReturn struct {
+	Code      string    `json:"code"`
+	UserRole  string    `json:"userRole"`
+	Campaign  string    `json:"campaign"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// createInvite creates a single use invite code. Admins can invite users with
+// any role, DMs can only invite players to their own campaigns.
+func createInvite(w http.ResponseWriter, r *http.Request) {
+	var postData createInvitePost
+	err := json.NewDecoder(r.Body).Decode(&postData)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	if postData.UserRole == "" {
+		postData.UserRole = dndinterface.RolePlayer
+	}
+	if !dndinterface.ValidRole(postData.UserRole) {
+		writeInvalid(w, fieldError{Field: "userRole", Message: "Must be admin, dm or player"})
+		return
+	}
+	postData.UserRole = strings.ToLower(postData.UserRole)
+
+	claims := requestClaims(r)
+	if !hasRole(claims, dndinterface.RoleAdmin) {
+		if postData.UserRole != dndinterface.RolePlayer || postData.Campaign == "" {
+			writeForbidden(w)
+			return
+		}
+	}
+	var campaign dndinterface.Campaign
+	if postData.Campaign != "" {
+		var ok bool
+		if campaign, ok = authorizeCampaign(w, r, postData.Campaign); !ok {
+			return
+		}
+	}
+
+	// Invite codes are as hard to guess as refresh tokens, so they are
+	// made and stored the same way
+	code, hash, err := newRefreshToken()
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	invite := dndinterface.Invite{
+		Hash:      hash,
+		CreatedBy: claims.Username,
+		UserRole:  postData.UserRole,
+		Campaign:  campaign.ID,
+		ExpiresAt: time.Now().Add(time.Duration(conf.InviteTTL)),
+	}
+	if err := db.AddInvite(r.Context(), invite); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createInviteReturn{
+		Code:      code,
+		UserRole:  invite.UserRole,
+		Campaign:  invite.Campaign,
+		ExpiresAt: invite.ExpiresAt,
+	})
+}
+
+type registerPost struct {
+	Code     string `json:"code"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email"`
+}
+
+// register creates a user from an invite code. The code is used up even if
+// the campaign it was made for has been removed since.
+func register(w http.ResponseWriter, r *http.Request) {
+	var postData registerPost
+	err := json.NewDecoder(r.Body).Decode(&postData)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	if postData.Username == "" {
+		writeInvalid(w, fieldError{Field: "username", Message: "Must not be empty"})
+		return
+	}
+	if postData.Email != "" && !validEmail(postData.Email) {
+		writeInvalid(w, fieldError{Field: "email", Message: "Not a valid email address"})
+		return
+	}
+
+	invite, err := db.UseInvite(r.Context(), hashToken(postData.Code))
+	if err == dndinterface.ErrNotFound {
+		writeError(w, http.StatusForbidden, codeForbidden, "The invite code is not valid or has expired")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	err = db.AddUser(r.Context(), postData.Username, postData.Password, invite.UserRole)
+	if err != nil {
+		// Give the invite back so a taken username or weak password
+		// does not cost the code
+		if restoreErr := db.AddInvite(r.Context(), invite); restoreErr != nil {
+			writeStoreError(w, restoreErr)
+			return
+		}
+		writeStoreError(w, err)
+		return
+	}
+
+	if postData.Email != "" {
+		if err := db.SetEmail(r.Context(), postData.Username, postData.Email); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+
+	if invite.Campaign != "" {
+		err := joinCampaign(r.Context(), invite, postData.Username)
+		if err != nil && err != dndinterface.ErrNotFound {
+			writeStoreError(w, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// joinCampaign adds a user to the players of the campaign of an invite.
+// Invites made before campaigns had IDs hold the name of the campaign, it is
+// looked up among the campaigns of whoever made the invite.
+func joinCampaign(ctx context.Context, invite dndinterface.Invite, username string) error {
+	campaign, err := findCampaign(ctx, invite.Campaign, invite.CreatedBy)
+	if err != nil {
+		return err
+	}
+	return db.AddCampaignPlayer(ctx, campaign.ID, username)
+}
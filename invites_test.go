@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+)
+
+// createTestInvite creates an invite through /createInvite and returns its code
+func createTestInvite(t *testing.T, router http.Handler, token, body string) string {
+	t.Helper()
+	w := serve(router, http.MethodPost, "/createInvite", token, body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("createInvite status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	var created createInviteReturn
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	return created.Code
+}
+
+func registerBody(code, username, password string) string {
+	return `{"code": "` + code + `", "username": "` + username + `", "password": "` + password + `"}`
+}
+
+func TestCreateInvite(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		user   string
+		body   string
+		status int
+	}{
+		{"admin invites a DM", "admin", `{"userRole": "dm"}`, http.StatusCreated},
+		{"admin invites to any campaign", "admin", `{"campaign": "{theirs}"}`, http.StatusCreated},
+		{"DM invites a player to their campaign", "dm", `{"userRole": "player", "campaign": "{own}"}`, http.StatusCreated},
+		{"DM invites a DM", "dm", `{"userRole": "dm", "campaign": "{own}"}`, http.StatusForbidden},
+		{"DM invites an admin", "dm", `{"userRole": "admin", "campaign": "{own}"}`, http.StatusForbidden},
+		{"DM invites without a campaign", "dm", `{"userRole": "player"}`, http.StatusForbidden},
+		{"DM invites to another DM's campaign", "dm", `{"userRole": "player", "campaign": "{theirs}"}`, http.StatusForbidden},
+		{"player invites", "p", `{"userRole": "player", "campaign": "{own}"}`, http.StatusForbidden},
+		{"unknown role", "admin", `{"userRole": "king"}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestStore(t)
+			tokens := addTestUsers(t, map[string]string{
+				"admin": dndinterface.RoleAdmin,
+				"dm":    dndinterface.RoleDM,
+				"other": dndinterface.RoleDM,
+				"p":     dndinterface.RolePlayer,
+			})
+			own, err := db.AddCampain(ctx, dndinterface.Campaign{Name: "own", DM: "dm", Players: []string{"p"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			theirs, err := db.AddCampain(ctx, dndinterface.Campaign{Name: "theirs", DM: "other"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body := strings.NewReplacer("{own}", own, "{theirs}", theirs).Replace(test.body)
+			w := serve(newRouter(), http.MethodPost, "/createInvite", tokens[test.user], body)
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestRegisterUsesCodeOnce(t *testing.T) {
+	ctx := context.Background()
+	useTestStore(t)
+	tokens := addTestUsers(t, map[string]string{"dm": dndinterface.RoleDM})
+	campaignID, err := db.AddCampain(ctx, dndinterface.Campaign{Name: "c", DM: "dm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter()
+	code := createTestInvite(t, router, tokens["dm"], `{"campaign": "`+campaignID+`"}`)
+
+	if w := serve(router, http.MethodPost, "/register", "", registerBody(code, "new", "new-password")); w.Code != http.StatusCreated {
+		t.Fatalf("register status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	user, err := db.GetUser(ctx, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.UserRole != dndinterface.RolePlayer {
+		t.Errorf("role = %q, want %q", user.UserRole, dndinterface.RolePlayer)
+	}
+	campaign, err := db.GetCampaignByID(ctx, campaignID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !campaign.HasPlayer("new") {
+		t.Errorf("players = %v, want the new user to have joined", campaign.Players)
+	}
+
+	if w := serve(router, http.MethodPost, "/register", "", registerBody(code, "again", "again-password")); w.Code != http.StatusForbidden {
+		t.Errorf("second register status = %d, want %d: %s", w.Code, http.StatusForbidden, w.Body)
+	}
+	if _, err := db.GetUser(ctx, "again"); err != dndinterface.ErrNotFound {
+		t.Errorf("GetUser() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRegisterExpiredCode(t *testing.T) {
+	ctx := context.Background()
+	useTestStore(t)
+	invite := dndinterface.Invite{
+		Hash:      hashToken("expired-code"),
+		CreatedBy: "admin",
+		UserRole:  dndinterface.RolePlayer,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}
+	if err := db.AddInvite(ctx, invite); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(newRouter(), http.MethodPost, "/register", "", registerBody("expired-code", "new", "new-password"))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusForbidden, w.Body)
+	}
+	if _, err := db.GetUser(ctx, "new"); err != dndinterface.ErrNotFound {
+		t.Errorf("GetUser() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRegisterGivesCodeBack(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		status   int
+	}{
+		{"weak password", "new", "short", http.StatusUnprocessableEntity},
+		{"taken username", "taken", "new-password", http.StatusConflict},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestStore(t)
+			tokens := addTestUsers(t, map[string]string{
+				"admin": dndinterface.RoleAdmin,
+				"taken": dndinterface.RolePlayer,
+			})
+			router := newRouter()
+			code := createTestInvite(t, router, tokens["admin"], `{"userRole": "dm"}`)
+
+			w := serve(router, http.MethodPost, "/register", "", registerBody(code, test.username, test.password))
+			if w.Code != test.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+
+			// The code still works for a fixed request
+			if w := serve(router, http.MethodPost, "/register", "", registerBody(code, "new", "new-password")); w.Code != http.StatusCreated {
+				t.Fatalf("retry status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+			}
+			user, err := db.GetUser(ctx, "new")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.UserRole != dndinterface.RoleDM {
+				t.Errorf("role = %q, want %q", user.UserRole, dndinterface.RoleDM)
+			}
+		})
+	}
+}
+
+func TestJoinCampaignByName(t *testing.T) {
+	ctx := context.Background()
+	useTestStore(t)
+	addTestUsers(t, map[string]string{
+		"dm":    dndinterface.RoleDM,
+		"other": dndinterface.RoleDM,
+		"new":   dndinterface.RolePlayer,
+	})
+	own, err := db.AddCampain(ctx, dndinterface.Campaign{Name: "c", DM: "dm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	theirs, err := db.AddCampain(ctx, dndinterface.Campaign{Name: "c", DM: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Invites from before campaign IDs name the campaign of whoever made them
+	invite := dndinterface.Invite{CreatedBy: "dm", UserRole: dndinterface.RolePlayer, Campaign: "c"}
+	if err := joinCampaign(ctx, invite, "new"); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[string]bool{own: true, theirs: false} {
+		campaign, err := db.GetCampaignByID(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if campaign.HasPlayer("new") != want {
+			t.Errorf("campaign of %s players = %v, want the new user in it: %v", campaign.DM, campaign.Players, want)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+)
+
+// loginAttempts is the failure record of one username or IP address
+type loginAttempts struct {
+	failures     uint64
+	pending      uint64
+	lastFailure  time.Time
+	blockedUntil time.Time
+	locked       bool
+}
+
+// loginLimiter slows down guessing passwords on /signin. Every failed login
+// blocks the username and the IP address for a delay that doubles with each
+// failure, after enough failures they are locked out for a longer time.
+// Attempts are reserved before the password is checked so parallel requests
+// can not get past the delay or the lockout.
+type loginLimiter struct {
+	mu        sync.Mutex
+	cfg       LoginLimitConfig
+	users     map[string]*loginAttempts
+	ips       map[string]*loginAttempts
+	lastPrune time.Time
+}
+
+func newLoginLimiter(cfg LoginLimitConfig) *loginLimiter {
+	return &loginLimiter{
+		cfg:   cfg,
+		users: map[string]*loginAttempts{},
+		ips:   map[string]*loginAttempts{},
+	}
+}
+
+// clientIP returns the address the request came from. Headers set by proxies
+// are not trusted since clients can send them too.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// busyWait is how long to wait for running attempts when no BaseDelay is
+// set, it has to be above zero for the attempt to be turned down
+const busyWait = time.Second
+
+// reserve starts a login attempt for the username and the IP. It returns how
+// long they have to wait before trying again, the attempt is only started
+// when that is zero and has to be ended with done.
+func (l *loginLimiter) reserve(username, ip string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+	users, ips := l.current(l.users, username, now), l.current(l.ips, ip, now)
+	var wait time.Duration
+	for _, a := range []*loginAttempts{users, ips} {
+		if a != nil && a.blockedUntil.Sub(now) > wait {
+			wait = a.blockedUntil.Sub(now)
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+	// A username gets one attempt at a time so the delay applies to each of
+	// them, an IP gets no more running attempts than its lockout threshold.
+	if users.pending > 0 || full(users, l.cfg.UserLockoutThreshold) || full(ips, l.cfg.IPLockoutThreshold) {
+		if l.cfg.BaseDelay > 0 {
+			return time.Duration(l.cfg.BaseDelay)
+		}
+		return busyWait
+	}
+
+	users.pending++
+	ips.pending++
+	return 0
+}
+
+// hit counts a request that is throttled like a failed login, such as asking
+// for a password reset. It returns how long to wait before trying again, the
+// request is only counted when that is zero.
+func (l *loginLimiter) hit(username, ip string, now time.Time) time.Duration {
+	if wait := l.reserve(username, ip, now); wait > 0 {
+		return wait
+	}
+	l.done(username, ip, dndinterface.ErrInvalidCredentials, now)
+	return 0
+}
+
+// current returns the record of a key, a new one if there is none, it has
+// expired or its lockout is over. Attempts that are still running are
+// carried over.
+func (l *loginLimiter) current(entries map[string]*loginAttempts, key string, now time.Time) *loginAttempts {
+	a := entries[key]
+	if a == nil || l.expired(a, now) || (a.locked && !now.Before(a.blockedUntil)) {
+		a = &loginAttempts{pending: pendingOf(a)}
+		entries[key] = a
+	}
+	return a
+}
+
+// full reports if the running attempts reach the lockout threshold
+func full(a *loginAttempts, threshold uint64) bool {
+	return threshold > 0 && a.pending >= threshold
+}
+
+// done ends an attempt started with reserve. A wrong password is recorded as
+// a failure of the username and the IP, a correct one forgets the failures of
+// the username. Other errors do not count.
+func (l *loginLimiter) done(username, ip string, err error, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, a := range []*loginAttempts{l.users[username], l.ips[ip]} {
+		if a != nil && a.pending > 0 {
+			a.pending--
+		}
+	}
+	switch {
+	case err == dndinterface.ErrInvalidCredentials:
+		l.record(l.users, username, l.cfg.UserLockoutThreshold, now)
+		l.record(l.ips, ip, l.cfg.IPLockoutThreshold, now)
+	case err == nil:
+		l.forget(username)
+	}
+}
+
+func (l *loginLimiter) record(entries map[string]*loginAttempts, key string, threshold uint64, now time.Time) {
+	a := l.current(entries, key, now)
+	a.failures++
+	a.lastFailure = now
+	if threshold > 0 && a.failures >= threshold {
+		a.locked = true
+		a.blockedUntil = now.Add(time.Duration(l.cfg.LockoutDuration))
+		return
+	}
+
+	delay := time.Duration(l.cfg.BaseDelay)
+	for i := uint64(1); i < a.failures && delay < time.Duration(l.cfg.MaxDelay); i++ {
+		delay *= 2
+	}
+	if delay > time.Duration(l.cfg.MaxDelay) {
+		delay = time.Duration(l.cfg.MaxDelay)
+	}
+	a.blockedUntil = now.Add(delay)
+}
+
+func pendingOf(a *loginAttempts) uint64 {
+	if a == nil {
+		return 0
+	}
+	return a.pending
+}
+
+// succeed forgets the failures of a username after a correct password. The
+// IP keeps its record so one valid account can not be used to reset it.
+func (l *loginLimiter) succeed(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.forget(username)
+}
+
+// forget drops the failures of a username, attempts that are still running
+// are kept
+func (l *loginLimiter) forget(username string) {
+	if pending := pendingOf(l.users[username]); pending > 0 {
+		l.users[username] = &loginAttempts{pending: pending}
+		return
+	}
+	delete(l.users, username)
+}
+
+// expired reports if a record is old enough to be forgotten
+func (l *loginLimiter) expired(a *loginAttempts, now time.Time) bool {
+	return now.After(a.blockedUntil) && now.Sub(a.lastFailure) > time.Duration(l.cfg.ResetAfter)
+}
+
+// prune drops forgotten records, at most once a minute
+func (l *loginLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for _, entries := range []map[string]*loginAttempts{l.users, l.ips} {
+		for key, a := range entries {
+			if a.pending == 0 && l.expired(a, now) {
+				delete(entries, key)
+			}
+		}
+	}
+}
+
+// lockoutGet describes a username or IP that can not sign in right now
+type lockoutGet struct {
+	Kind         string    `json:"kind"`
+	Name         string    `json:"name"`
+	Failures     uint64    `json:"failures"`
+	Locked       bool      `json:"locked"`
+	BlockedUntil time.Time `json:"blockedUntil"`
+}
+
+// blocked lists every username and IP that is blocked at the moment
+func (l *loginLimiter) blocked(now time.Time) []lockoutGet {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := []lockoutGet{}
+	for kind, entries := range map[string]map[string]*loginAttempts{"user": l.users, "ip": l.ips} {
+		for name, a := range entries {
+			if a.blockedUntil.After(now) {
+				list = append(list, lockoutGet{Kind: kind, Name: name, Failures: a.failures, Locked: a.locked, BlockedUntil: a.blockedUntil})
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Kind != list[j].Kind {
+			return list[i].Kind > list[j].Kind
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// clear removes the record of a username or an IP, it reports if there was one
+func (l *loginLimiter) clear(kind, name string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := l.users
+	if kind == "ip" {
+		entries = l.ips
+	}
+	_, found := entries[name]
+	delete(entries, name)
+	return found
+}
+
+// writeTooManyAttempts tells the client when it can try again
+func writeTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(wait / time.Second)
+	if wait%time.Second != 0 {
+		seconds++
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeError(w, http.StatusTooManyRequests, codeTooManyRequests,
+		"Too many attempts, try again in "+strconv.Itoa(seconds)+" seconds")
+}
+
+// getLockouts lists blocked usernames and IPs
+func getLockouts(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(logins.blocked(time.Now()))
+}
+
+type clearLockoutPost struct {
+	Username string `json:"username"`
+	IP       string `json:"ip"`
+}
+
+// clearLockout lets a username or an IP sign in again right away
+func clearLockout(w http.ResponseWriter, r *http.Request) {
+	var postData clearLockoutPost
+	err := json.NewDecoder(r.Body).Decode(&postData)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	found := false
+	if postData.Username != "" {
+		found = logins.clear("user", postData.Username) || found
+	}
+	if postData.IP != "" {
+		found = logins.clear("ip", postData.IP) || found
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, codeNotFound, "No lockout found")
+	}
+}
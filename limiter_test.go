@@ -0,0 +1,169 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+)
+
+func testLimiter() *loginLimiter {
+	return newLoginLimiter(LoginLimitConfig{
+		BaseDelay:            dndinterface.Duration(time.Second),
+		MaxDelay:             dndinterface.Duration(8 * time.Second),
+		UserLockoutThreshold: 5,
+		IPLockoutThreshold:   20,
+		LockoutDuration:      dndinterface.Duration(15 * time.Minute),
+		ResetAfter:           dndinterface.Duration(time.Hour),
+	})
+}
+
+func TestLoginLimiter(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		failures int
+		wait     time.Duration
+		locked   bool
+	}{
+		{"no failures", 0, 0, false},
+		{"first failure", 1, time.Second, false},
+		{"backoff doubles", 3, 4 * time.Second, false},
+		{"backoff is capped", 4, 8 * time.Second, false},
+		{"lockout at the threshold", 5, 15 * time.Minute, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := testLimiter()
+			for i := 0; i < test.failures; i++ {
+				l.done("p", "10.0.0.1", dndinterface.ErrInvalidCredentials, now)
+			}
+
+			if wait := l.reserve("p", "10.0.0.1", now); wait != test.wait {
+				t.Errorf("reserve() = %v, want %v", wait, test.wait)
+			}
+			locked := false
+			for _, entry := range l.blocked(now) {
+				if entry.Kind == "user" && entry.Name == "p" {
+					locked = entry.Locked
+				}
+			}
+			if locked != test.locked {
+				t.Errorf("locked = %v, want %v", locked, test.locked)
+			}
+		})
+	}
+}
+
+func TestLoginLimiterSucceed(t *testing.T) {
+	now := time.Now()
+	l := testLimiter()
+	l.done("p", "10.0.0.1", dndinterface.ErrInvalidCredentials, now)
+	l.succeed("p")
+
+	if wait := l.reserve("p", "10.0.0.2", now); wait != 0 {
+		t.Errorf("reserve() for the username = %v, want 0", wait)
+	}
+	if wait := l.reserve("q", "10.0.0.1", now); wait != time.Second {
+		t.Errorf("reserve() for the IP = %v, want %v", wait, time.Second)
+	}
+}
+
+func TestLoginLimiterParallel(t *testing.T) {
+	now := time.Now()
+
+	t.Run("running attempts count towards the IP lockout", func(t *testing.T) {
+		l := testLimiter()
+		for i := 0; i < 20; i++ {
+			if wait := l.reserve("user"+strconv.Itoa(i), "10.0.0.1", now); wait != 0 {
+				t.Fatalf("reserve() number %d = %v, want 0", i+1, wait)
+			}
+		}
+		if wait := l.reserve("other", "10.0.0.1", now); wait == 0 {
+			t.Fatal("reserve() started more attempts than the threshold")
+		}
+		for i := 0; i < 20; i++ {
+			l.done("user"+strconv.Itoa(i), "10.0.0.1", dndinterface.ErrInvalidCredentials, now)
+		}
+		if wait := l.reserve("other", "10.0.0.1", now); wait != 15*time.Minute {
+			t.Errorf("reserve() after the lockout = %v, want %v", wait, 15*time.Minute)
+		}
+	})
+
+	t.Run("one attempt at a time for a username", func(t *testing.T) {
+		l := testLimiter()
+		if wait := l.reserve("p", "10.0.0.1", now); wait != 0 {
+			t.Fatalf("reserve() = %v, want 0", wait)
+		}
+		if wait := l.reserve("p", "10.0.0.2", now); wait == 0 {
+			t.Fatal("reserve() started a second attempt for the username")
+		}
+		l.done("p", "10.0.0.1", dndinterface.ErrInvalidCredentials, now)
+		if wait := l.reserve("p", "10.0.0.2", now); wait != time.Second {
+			t.Errorf("reserve() after a failure = %v, want %v", wait, time.Second)
+		}
+	})
+}
+
+func TestLoginLimiterAfterLockout(t *testing.T) {
+	now := time.Now()
+	l := testLimiter()
+	for i := 0; i < 5; i++ {
+		l.done("p", "10.0.0.1", dndinterface.ErrInvalidCredentials, now)
+	}
+	if wait := l.reserve("p", "10.0.0.1", now); wait != 15*time.Minute {
+		t.Fatalf("reserve() during the lockout = %v, want %v", wait, 15*time.Minute)
+	}
+
+	after := now.Add(15 * time.Minute)
+	if wait := l.reserve("p", "10.0.0.1", after); wait != 0 {
+		t.Fatalf("reserve() right after the lockout = %v, want 0", wait)
+	}
+	l.done("p", "10.0.0.1", dndinterface.ErrInvalidCredentials, after)
+
+	// The failures before the lockout are forgotten and the backoff of the
+	// username starts over. Another IP is used since the first one keeps its
+	// own failures.
+	if wait := l.reserve("p", "10.0.0.2", after); wait != time.Second {
+		t.Errorf("reserve() after a new failure = %v, want %v", wait, time.Second)
+	}
+	for _, entry := range l.blocked(after) {
+		if entry.Kind == "user" && (entry.Failures != 1 || entry.Locked) {
+			t.Errorf("blocked() = %+v, want one failure and no lockout", entry)
+		}
+	}
+}
+
+func TestLoginLimiterNoDelay(t *testing.T) {
+	now := time.Now()
+	l := newLoginLimiter(LoginLimitConfig{
+		UserLockoutThreshold: 2,
+		IPLockoutThreshold:   2,
+		LockoutDuration:      dndinterface.Duration(15 * time.Minute),
+		ResetAfter:           dndinterface.Duration(time.Hour),
+	})
+
+	// Without a delay running attempts still have to turn others down
+	granted := 0
+	for i := 0; i < 20; i++ {
+		if l.reserve("p", "10.0.0.1", now) == 0 {
+			granted++
+		}
+	}
+	if granted != 1 {
+		t.Errorf("%d attempts for one username were started, want 1", granted)
+	}
+
+	granted = 0
+	for i := 0; i < 20; i++ {
+		if l.reserve("user"+strconv.Itoa(i), "10.0.0.2", now) == 0 {
+			granted++
+		}
+	}
+	if granted != 2 {
+		t.Errorf("%d attempts for one IP were started, want 2", granted)
+	}
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	dbinterface "github.com/Typelias/DnDBackend/DBInterface"
@@ -16,19 +19,24 @@ import (
 	"github.com/gorilla/mux"
 )
 
-var jwtKey = []byte(os.Getenv("JWTKey"))
+var jwtKeys keyring
 
-var users = map[string]string{
-	"typelias": "pass",
-	"rass":     "pass",
-}
+var db dndinterface.Store
+
+var conf Config
+
+var logins *loginLimiter
 
-var db dndinterface.DBInterface
+var resetRequests *loginLimiter
 
-//Credentials is used to parse incoming login data
+var notifier Notifier
+
+//Credentials is used to parse incoming login data. Clients that can not keep
+//cookies set ReturnToken to get the tokens in the response body.
 type Credentials struct {
-	Password string `json:"password"`
-	Username string `json:"username"`
+	Password    string `json:"password"`
+	Username    string `json:"username"`
+	ReturnToken bool   `json:"returnToken"`
 }
 
 // Claims is used to set claims when token is created
@@ -36,6 +44,10 @@ type Claims struct {
 	Username string `json:"username"`
 	Type     string `json:"Type"`
 	jwt.StandardClaims
+
+	// Set when the request was made with an API key instead of a token
+	APIKeyID string   `json:"-"`
+	Scopes   []string `json:"-"`
 }
 
 func signIn(w http.ResponseWriter, r *http.Request) {
@@ -46,108 +58,141 @@ func signIn(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&creds)
 
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeBadRequest(w, err)
 		return
 	}
 
-	userRole, authorized := db.CheckUser(creds.Username, creds.Password)
-
-	if !authorized {
-		w.WriteHeader(http.StatusUnauthorized)
+	ip := clientIP(r)
+	if wait := logins.reserve(creds.Username, ip, time.Now()); wait > 0 {
+		writeTooManyAttempts(w, wait)
 		return
 	}
 
-	experationTime := time.Now().Add(48 * time.Hour)
-
-	claims := &Claims{
-		Username: creds.Username,
-		Type:     userRole,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: experationTime.Unix(),
-		},
+	userRole, err := db.CheckUser(r.Context(), creds.Username, creds.Password)
+	logins.done(creds.Username, ip, err, time.Now())
+	if err != nil {
+		writeStoreError(w, err)
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	tokenString, err := token.SignedString(jwtKey)
-
+	tokens, err := issueTokens(w, r, creds.Username, userRole)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(w, err)
 		return
 	}
 
-	// fmt.Println(tokenString)
-
-	cookie := &http.Cookie{
-		Name:    "token",
-		Value:   tokenString,
-		Expires: experationTime,
+	if creds.ReturnToken {
+		json.NewEncoder(w).Encode(tokens)
 	}
-
-	fmt.Println(cookie)
-
-	http.SetCookie(w, cookie)
 }
 
 func isAuthorized(endpoint func(http.ResponseWriter, *http.Request)) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		c, err := r.Cookie("token")
-		if err != nil {
-			if err == http.ErrNoCookie {
-				w.WriteHeader(http.StatusUnauthorized)
+		var claims *Claims
+		var err error
+		if key := r.Header.Get("X-API-Key"); key != "" {
+			claims, err = apiKeyClaims(r, key)
+			if err == dndinterface.ErrInvalidCredentials {
+				writeError(w, http.StatusUnauthorized, codeUnauthorized, "The API key is not valid or has been revoked")
+				return
+			}
+			if err != nil {
+				writeStoreError(w, err)
+				return
+			}
+		} else {
+			tokenString, found := requestToken(r)
+			if !found {
+				writeError(w, http.StatusUnauthorized, codeUnauthorized, "Sign in first")
 				return
 			}
 
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-
-		tknStr := c.Value
-
-		tkn, err := jwt.Parse(tknStr, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
-
-		if err != nil {
-			if err == jwt.ErrSignatureInvalid {
-				w.WriteHeader(http.StatusUnauthorized)
+			// Expired, badly signed and malformed tokens all mean the client
+			// has to sign in again
+			claims, err = parseToken(tokenString)
+			if err != nil {
+				writeError(w, http.StatusUnauthorized, codeUnauthorized, "The token is not valid or has expired")
 				return
 			}
-			w.WriteHeader(http.StatusBadRequest)
-			return
 		}
 
-		if !tkn.Valid {
-			w.WriteHeader(http.StatusUnauthorized)
+		if !routeAllowed(r, claims) {
+			writeForbidden(w)
 			return
 		}
 
-		endpoint(w, r)
+		endpoint(w, withClaims(r, claims))
 	})
 }
 
+// writeStoreError writes the error response for a failed store call
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, context.Canceled) {
+		// The client has gone away, there is nobody to answer
+		return
+	}
+
+	var patchErr *dndinterface.PatchError
+	switch {
+	case errors.As(err, &patchErr):
+		writeInvalid(w, fieldError{Field: patchErr.Field, Message: patchErr.Reason})
+	case errors.Is(err, dndinterface.ErrNotFound):
+		writeError(w, http.StatusNotFound, codeNotFound, "Not found")
+	case errors.Is(err, dndinterface.ErrAlreadyExists):
+		writeError(w, http.StatusConflict, codeAlreadyExists, "It already exists")
+	case errors.Is(err, dndinterface.ErrConflict):
+		writeError(w, http.StatusConflict, codeConflict, "It conflicts with something that already exists")
+	case errors.Is(err, dndinterface.ErrInvalidID):
+		writeError(w, http.StatusUnprocessableEntity, codeInvalidID, "Not a valid ID")
+	case errors.Is(err, dndinterface.ErrWeakPassword):
+		writeError(w, http.StatusUnprocessableEntity, codeWeakPassword, "The "+err.Error())
+	case errors.Is(err, dndinterface.ErrInvalidCredentials):
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Wrong username or password")
+	case errors.Is(err, dndinterface.ErrTimeout):
+		writeError(w, http.StatusGatewayTimeout, codeTimeout, "The database took too long to answer")
+	default:
+		fmt.Println("Request", w.Header().Get(requestIDHeader)+":", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Something went wrong")
+	}
+}
+
 func addUser(w http.ResponseWriter, r *http.Request) {
 	var user dndinterface.User
 
 	err := json.NewDecoder(r.Body).Decode(&user)
 
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeBadRequest(w, err)
 		return
 	}
 
-	res := db.AddUser(user.Username, user.Password, user.UserRole)
+	if user.UserRole == "" {
+		user.UserRole = dndinterface.RolePlayer
+	}
+	if fields := userFieldErrors("", user); len(fields) > 0 {
+		writeInvalid(w, fields...)
+		return
+	}
 
-	if res {
-		w.WriteHeader(http.StatusOK)
+	err = db.AddUser(r.Context(), user.Username, user.Password, strings.ToLower(user.UserRole))
+	if err == nil && user.Email != "" {
+		err = db.SetEmail(r.Context(), user.Username, user.Email)
+	}
 
+	if err != nil {
+		writeStoreError(w, err)
 	} else {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusOK)
 	}
 }
 
 func getUserList(w http.ResponseWriter, r *http.Request) {
-	json.NewEncoder(w).Encode(db.GetAllUsers())
+	users, err := db.GetAllUsers(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(users)
 }
 
 type userDeletePost struct {
@@ -158,15 +203,18 @@ func deleteUser(w http.ResponseWriter, r *http.Request) {
 	var username userDeletePost
 	err := json.NewDecoder(r.Body).Decode(&username)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeBadRequest(w, err)
+		return
 	}
 
-	res := db.DeleteUser(username.Username)
-
-	if res {
-		w.WriteHeader(http.StatusOK)
+	if !canChangeUser(w, r, username.Username, "") {
+		return
+	}
+	err = db.DeleteUser(r.Context(), username.Username)
+	if err != nil {
+		writeStoreError(w, err)
 	} else {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusOK)
 	}
 
 }
@@ -180,15 +228,25 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	var postData userUpdatePost
 	err := json.NewDecoder(r.Body).Decode(&postData)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeBadRequest(w, err)
+		return
+	}
+
+	if fields := userFieldErrors("user.", postData.User); len(fields) > 0 {
+		writeInvalid(w, fields...)
+		return
+	}
+	postData.User.UserRole = strings.ToLower(postData.User.UserRole)
+	if !canChangeUser(w, r, postData.UserToUpdate, postData.User.UserRole) {
+		return
 	}
 
-	res := db.UpdateUser(postData.User, postData.UserToUpdate)
+	err = db.UpdateUser(r.Context(), postData.User, postData.UserToUpdate)
 
-	if res {
-		w.WriteHeader(http.StatusOK)
+	if err != nil {
+		writeStoreError(w, err)
 	} else {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusOK)
 	}
 
 }
@@ -197,19 +255,44 @@ func addCampaign(w http.ResponseWriter, r *http.Request) {
 	var postData dbinterface.Campaign
 	err := json.NewDecoder(r.Body).Decode(&postData)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeBadRequest(w, err)
+		return
 	}
 
-	res := db.AddCampain(postData)
-	if res {
-		w.WriteHeader(http.StatusOK)
-	} else {
-		w.WriteHeader(http.StatusInternalServerError)
+	if campaign, ok := createCampaign(w, r, postData); ok {
+		json.NewEncoder(w).Encode(campaign)
+	}
+}
+
+// createCampaign adds a campaign and returns it with its ID. On failure the
+// error response is already written.
+func createCampaign(w http.ResponseWriter, r *http.Request, campaign dndinterface.Campaign) (dndinterface.Campaign, bool) {
+	// A DM can only create campaigns for themselves. Characters join with
+	// AddCharacter, where their ownership is checked.
+	if claims := requestClaims(r); !hasRole(claims, dndinterface.RoleAdmin) {
+		campaign.DM = claims.Username
+	}
+	campaign.Characters = []string{}
+	if !checkCampaign(w, r, "", campaign) {
+		return dndinterface.Campaign{}, false
 	}
+
+	id, err := db.AddCampain(r.Context(), campaign)
+	if err != nil {
+		writeStoreError(w, err)
+		return dndinterface.Campaign{}, false
+	}
+	campaign.ID = id
+	return campaign, true
 }
 
 func getAllCampaigns(w http.ResponseWriter, r *http.Request) {
-	json.NewEncoder(w).Encode(db.GetAllCampains())
+	campaigns, err := db.GetAllCampains(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(campaigns)
 }
 
 type userCampaignGet struct {
@@ -220,22 +303,36 @@ func getUserCampaigns(w http.ResponseWriter, r *http.Request) {
 	var user userCampaignGet
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeBadRequest(w, err)
+		return
 	}
 
-	json.NewEncoder(w).Encode(db.GetUserCampaign(user.User))
+	campaigns, err := db.GetUserCampaign(r.Context(), targetUsername(r, user.User))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(campaigns)
 }
 
 func getDMCampaigns(w http.ResponseWriter, r *http.Request) {
 	var user userCampaignGet
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeBadRequest(w, err)
+		return
 	}
 
-	json.NewEncoder(w).Encode(db.GetDMCampaign(user.User))
+	campaigns, err := db.GetDMCampaign(r.Context(), targetUsername(r, user.User))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(campaigns)
 }
 
+// campaignNameGet names a campaign by its ID, or by its name if no other DM
+// uses the same name
 type campaignNameGet struct {
 	Name string `json:"name"`
 }
@@ -244,10 +341,20 @@ func getCampaignByName(w http.ResponseWriter, r *http.Request) {
 	var postData campaignNameGet
 	err := json.NewDecoder(r.Body).Decode(&postData)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeBadRequest(w, err)
+		return
 	}
 
-	json.NewEncoder(w).Encode(db.GetCampaignByName(postData.Name))
+	campaign, err := findCampaign(r.Context(), postData.Name, requestClaims(r).Username)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !isCampaignMember(r, campaign) {
+		writeForbidden(w)
+		return
+	}
+	json.NewEncoder(w).Encode(campaign)
 }
 
 type campaignRemoveGet struct {
@@ -258,14 +365,20 @@ func removeCampaign(w http.ResponseWriter, r *http.Request) {
 	var name campaignRemoveGet
 	err := json.NewDecoder(r.Body).Decode(&name)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeBadRequest(w, err)
+		return
 	}
 
-	res := db.RemoveCampaign(name.Name)
-	if res {
-		w.WriteHeader(http.StatusOK)
+	campaign, ok := authorizeCampaign(w, r, name.Name)
+	if !ok {
+		return
+	}
+
+	err = db.RemoveCampaign(r.Context(), campaign.ID)
+	if err != nil {
+		writeStoreError(w, err)
 	} else {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusOK)
 	}
 }
 
@@ -278,15 +391,24 @@ func updateCampaign(w http.ResponseWriter, r *http.Request) {
 	var postData camapaignUpdatePost
 	err := json.NewDecoder(r.Body).Decode(&postData)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeBadRequest(w, err)
+		return
 	}
 
-	res := db.UpdateCampaign(postData.NameOfCampaign, postData.Campaign)
+	campaign, ok := authorizeCampaign(w, r, postData.NameOfCampaign)
+	if !ok {
+		return
+	}
+	if !checkCampaign(w, r, "campaign.", postData.Campaign) || !canChangeDM(w, r, campaign, postData.Campaign.DM) {
+		return
+	}
 
-	if res {
-		w.WriteHeader(http.StatusOK)
+	err = db.UpdateCampaign(r.Context(), campaign.ID, postData.Campaign)
+
+	if err != nil {
+		writeStoreError(w, err)
 	} else {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusOK)
 	}
 }
 
@@ -299,19 +421,46 @@ func addCharacter(w http.ResponseWriter, r *http.Request) {
 	var postData characterAddPost
 	err := json.NewDecoder(r.Body).Decode(&postData)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeBadRequest(w, err)
+		return
 	}
 
-	fmt.Println(postData.Character)
+	campaign, err := findCampaign(r.Context(), postData.NameOfCampaign, requestClaims(r).Username)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
 
-	res := db.AddCharacter(postData.NameOfCampaign, postData.Character)
-	if res {
+	if _, ok := createCharacter(w, r, campaign, "character.", postData.Character); ok {
 		w.WriteHeader(http.StatusOK)
-	} else {
-		w.WriteHeader(http.StatusBadRequest)
 	}
 }
 
+// createCharacter adds a character to a campaign the caller takes part in
+// and returns its ID. Field errors are named with prefix in front. On failure
+// the error response is already written.
+func createCharacter(w http.ResponseWriter, r *http.Request, campaign dndinterface.Campaign, prefix string, ch dndinterface.Character) (string, bool) {
+	if !isCampaignMember(r, campaign) {
+		writeForbidden(w)
+		return "", false
+	}
+
+	// Only the DM can create characters on behalf of someone else
+	if ch.Owner == "" || !canManageCampaign(r, campaign) {
+		ch.Owner = requestClaims(r).Username
+	}
+	if !checkCharacter(w, r, prefix, ch) {
+		return "", false
+	}
+
+	id, err := db.AddCharacter(r.Context(), campaign.ID, ch)
+	if err != nil {
+		writeStoreError(w, err)
+		return "", false
+	}
+	return id, true
+}
+
 type characterUpdatePost struct {
 	ID        string                `json:"id"`
 	Character dbinterface.Character `json:"character"`
@@ -321,19 +470,40 @@ func updateCharacter(w http.ResponseWriter, r *http.Request) {
 	var postData characterUpdatePost
 	err := json.NewDecoder(r.Body).Decode(&postData)
 	if err != nil {
-		fmt.Println(err)
-		fmt.Println("Wrong data")
-		w.WriteHeader(http.StatusBadRequest)
+		writeBadRequest(w, err)
+		return
 	}
 
-	res := db.UpdateCharacter(postData.ID, postData.Character)
-	if res {
+	if saveCharacter(w, r, postData.ID, "character.", postData.Character) {
 		w.WriteHeader(http.StatusOK)
-	} else {
-		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
+// saveCharacter replaces a character the caller may edit. Field errors are
+// named with prefix in front. On failure the error response is already
+// written.
+func saveCharacter(w http.ResponseWriter, r *http.Request, id, prefix string, ch dndinterface.Character) bool {
+	old, handOver, ok := editCharacter(w, r, id)
+	if !ok {
+		return false
+	}
+
+	// Clients that do not know about owners leave the field out, that keeps
+	// the owner like it does for callers that can not hand characters over
+	if !handOver || ch.Owner == "" {
+		ch.Owner = old.Owner
+	}
+	if !checkCharacter(w, r, prefix, ch) {
+		return false
+	}
+
+	if err := db.UpdateCharacter(r.Context(), id, ch); err != nil {
+		writeStoreError(w, err)
+		return false
+	}
+	return true
+}
+
 type characterGetPost struct {
 	ID string `json:"id"`
 }
@@ -342,16 +512,55 @@ func getCharacter(w http.ResponseWriter, r *http.Request) {
 	var postData characterGetPost
 	err := json.NewDecoder(r.Body).Decode(&postData)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeBadRequest(w, err)
+		return
 	}
-	ch, res := db.GetCharacterByID(postData.ID)
-	if res {
+	if ch, ok := loadCharacter(w, r, postData.ID); ok {
 		json.NewEncoder(w).Encode(ch)
-	} else {
-		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
+// editCharacter gets a character the caller may edit. It also reports if the
+// caller may hand the character over to another owner, which only the DM of
+// its campaign can do. On failure the error response is already written.
+func editCharacter(w http.ResponseWriter, r *http.Request, id string) (dndinterface.Character, bool, bool) {
+	ch, err := db.GetCharacterByID(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return ch, false, false
+	}
+	campaign, err := characterCampaign(r, id)
+	if err != nil {
+		writeStoreError(w, err)
+		return ch, false, false
+	}
+	if !canEditCharacter(r, campaign, ch) {
+		writeForbidden(w)
+		return ch, false, false
+	}
+	return ch, canManageCampaign(r, campaign), true
+}
+
+// loadCharacter gets a character the caller may see. On failure the error
+// response is already written.
+func loadCharacter(w http.ResponseWriter, r *http.Request, id string) (dndinterface.Character, bool) {
+	ch, err := db.GetCharacterByID(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return ch, false
+	}
+	campaign, err := characterCampaign(r, id)
+	if err != nil {
+		writeStoreError(w, err)
+		return ch, false
+	}
+	if !canViewCharacter(r, campaign, ch) {
+		writeForbidden(w)
+		return ch, false
+	}
+	return ch, true
+}
+
 type multiCharacterGetPost struct {
 	IDs []string `json:"ids"`
 }
@@ -360,19 +569,120 @@ func getMultiCharacter(w http.ResponseWriter, r *http.Request) {
 	var postData multiCharacterGetPost
 	err := json.NewDecoder(r.Body).Decode(&postData)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeBadRequest(w, err)
+		return
 	}
 
-	json.NewEncoder(w).Encode(db.GetMultiCharacter(postData.IDs))
+	visible, err := visibleCharacters(r, postData.IDs)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(visible)
+
+}
+
+// visibleCharacters gets the characters the caller may see. Characters the
+// caller may not see are left out like missing ones.
+func visibleCharacters(r *http.Request, ids []string) ([]dndinterface.MultiCharacterGetReturn, error) {
+	characters, err := db.GetMultiCharacter(r.Context(), ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var visible []dndinterface.MultiCharacterGetReturn
+	for _, v := range characters {
+		campaign, err := characterCampaign(r, v.ID)
+		if err != nil {
+			return nil, err
+		}
+		if canViewCharacter(r, campaign, v.Character) {
+			visible = append(visible, v)
+		}
+	}
+	return visible, nil
+}
+
+func openStore(cfg Config) (dndinterface.Store, func() error, error) {
+	switch cfg.DBBackend {
+	case "memory":
+		fmt.Println("Using in-memory database")
+		return dndinterface.NewMemoryDB(), func() error { return nil }, nil
+	case "bolt":
+		fmt.Println("Using bolt database at", cfg.DBPath)
+		boltDB, err := dndinterface.NewBoltDB(cfg.DBPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return boltDB, boltDB.Close, nil
+	case "mongo", "":
+		fmt.Println("Using mongo database at", cfg.Mongo.URI)
+		mongoDB := &dndinterface.DBInterface{}
+		if err := mongoDB.Init(cfg.Mongo); err != nil {
+			return nil, nil, err
+		}
+		return mongoDB, mongoDB.Close, nil
+	}
 
+	return nil, nil, fmt.Errorf("unknown database backend %q", cfg.DBBackend)
 }
 
 func main() {
-	db.Init()
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Println("Invalid configuration:", err)
+		os.Exit(1)
+	}
+	conf = cfg
+	jwtKeys = newKeyring(cfg.signingKeys())
+	logins = newLoginLimiter(cfg.Login)
+	resetRequests = newLoginLimiter(cfg.Login)
+	notifier, err = newNotifier(cfg)
+	if err != nil {
+		fmt.Println("Invalid configuration:", err)
+		os.Exit(1)
+	}
 
+	store, closeStore, err := openStore(cfg)
+	if err != nil {
+		fmt.Println("Could not open database:", err)
+		os.Exit(1)
+	}
+	defer closeStore()
+	db = store
+
+	if err := bootstrapAdmin(cfg.Admin); err != nil {
+		fmt.Println("Could not set up the first admin:", err)
+		os.Exit(1)
+	}
+
+	router := newRouter()
+
+	headers := handlers.AllowedHeaders([]string{"accept", "authorization", "content-type", "x-api-key", "x-request-id"})
+	methods := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"})
+	origins := handlers.AllowedOrigins([]string{"http://localhost:4200", "http://172.25.240.76:4200", "https://localhost:4200"})
+	x := handlers.ExposedHeaders([]string{"Set-Cookie", requestIDHeader})
+	cred := handlers.AllowCredentials()
+
+	fmt.Println("Server started")
+
+	//http.ListenAndServeTLS(":8081", "./server.crt", "./server.key", handlers.CORS(headers, methods, origins, x, cred)(router))
+
+	log.Fatal(http.ListenAndServe(":8081", handlers.CORS(headers, methods, origins, x, cred)(withRequestID(router))))
+
+}
+
+// newRouter registers the handlers of every route
+func newRouter() *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
 
 	router.HandleFunc("/signin", signIn).Methods("POST", "OPTIONS")
+	router.HandleFunc("/refresh", refresh).Methods("POST", "OPTIONS")
+	router.HandleFunc("/signout", signOut).Methods("POST", "OPTIONS")
+	router.HandleFunc("/register", register).Methods("POST", "OPTIONS")
+	router.HandleFunc("/forgotPassword", forgotPassword).Methods("POST", "OPTIONS")
+	router.HandleFunc("/resetPassword", resetPassword).Methods("POST", "OPTIONS")
+	router.Handle("/signoutEverywhere", isAuthorized(signOutEverywhere)).Methods("POST", "OPTIONS")
 	router.Handle("/addUser", isAuthorized(addUser)).Methods("POST", "OPTIONS")
 	router.Handle("/getUserList", isAuthorized(getUserList)).Methods("GET")
 	router.Handle("/deleteUser", isAuthorized(deleteUser)).Methods("POST", "OPTIONS")
@@ -388,17 +698,19 @@ func main() {
 	router.Handle("/updateCharacter", isAuthorized(updateCharacter)).Methods("POST", "OPTIONS")
 	router.Handle("/getCharacter", isAuthorized(getCharacter)).Methods("POST", "OPTIONS")
 	router.Handle("/getMultiCharacter", isAuthorized(getMultiCharacter)).Methods("POST", "OPTIONS")
-
-	headers := handlers.AllowedHeaders([]string{"accept", "authorization", "content-type"})
-	methods := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"})
-	origins := handlers.AllowedOrigins([]string{"http://localhost:4200", "http://172.25.240.76:4200", "https://localhost:4200"})
-	x := handlers.ExposedHeaders([]string{"Set-Cookie"})
-	cred := handlers.AllowCredentials()
-
-	fmt.Println("Server started")
-
-	//http.ListenAndServeTLS(":8081", "./server.crt", "./server.key", handlers.CORS(headers, methods, origins, x, cred)(router))
-
-	log.Fatal(http.ListenAndServe(":8081", handlers.CORS(headers, methods, origins, x, cred)(router)))
-
+	router.Handle("/myCampaigns", isAuthorized(myCampaigns)).Methods("GET")
+	router.Handle("/myCharacters", isAuthorized(myCharacters)).Methods("GET")
+	router.Handle("/me", isAuthorized(me)).Methods("GET")
+	router.Handle("/changePassword", isAuthorized(changePassword)).Methods("POST", "OPTIONS")
+	router.Handle("/changeEmail", isAuthorized(changeEmail)).Methods("POST", "OPTIONS")
+	router.Handle("/deleteAccount", isAuthorized(deleteAccount)).Methods("POST", "OPTIONS")
+	router.Handle("/createAPIKey", isAuthorized(createAPIKey)).Methods("POST", "OPTIONS")
+	router.Handle("/apiKeys", isAuthorized(getAPIKeys)).Methods("GET")
+	router.Handle("/revokeAPIKey", isAuthorized(revokeAPIKey)).Methods("POST", "OPTIONS")
+	router.Handle("/createInvite", isAuthorized(createInvite)).Methods("POST", "OPTIONS")
+	router.Handle("/lockouts", isAuthorized(getLockouts)).Methods("GET")
+	router.Handle("/clearLockout", isAuthorized(clearLockout)).Methods("POST", "OPTIONS")
+
+	registerV2(router)
+	return router
 }
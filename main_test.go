@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+)
+
+func TestUpdateUserRename(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		dm     string
+		player string
+		owner  string
+	}{
+		{
+			name:   "rename a player",
+			body:   `{"userToUpdate": "p", "user": {"Username": "q", "UserRole": "player"}}`,
+			status: http.StatusOK,
+			dm:     "dm",
+			player: "q",
+			owner:  "q",
+		},
+		{
+			name:   "rename a DM",
+			body:   `{"userToUpdate": "dm", "user": {"Username": "gm", "UserRole": "dm"}}`,
+			status: http.StatusOK,
+			dm:     "gm",
+			player: "p",
+			owner:  "p",
+		},
+		{
+			name:   "empty username",
+			body:   `{"userToUpdate": "p", "user": {"Username": "", "UserRole": "player"}}`,
+			status: http.StatusUnprocessableEntity,
+			dm:     "dm",
+			player: "p",
+			owner:  "p",
+		},
+		{
+			name:   "name that is taken",
+			body:   `{"userToUpdate": "p", "user": {"Username": "dm", "UserRole": "player"}}`,
+			status: http.StatusConflict,
+			dm:     "dm",
+			player: "p",
+			owner:  "p",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestStore(t)
+			tokens := addTestUsers(t, map[string]string{
+				"admin": dndinterface.RoleAdmin,
+				"dm":    dndinterface.RoleDM,
+				"p":     dndinterface.RolePlayer,
+			})
+			campaignID, err := db.AddCampain(ctx, dndinterface.Campaign{Name: "c", DM: "dm", Players: []string{"p"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			characterID, err := db.AddCharacter(ctx, campaignID, dndinterface.Character{CharacterName: "Vex", Owner: "p"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := serve(newRouter(), http.MethodPost, "/updateUser", tokens["admin"], test.body)
+			if w.Code != test.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+
+			campaign, err := db.GetCampaignByID(ctx, campaignID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if campaign.DM != test.dm {
+				t.Errorf("campaign DM = %q, want %q", campaign.DM, test.dm)
+			}
+			if len(campaign.Players) != 1 || campaign.Players[0] != test.player {
+				t.Errorf("campaign players = %v, want [%s]", campaign.Players, test.player)
+			}
+			ch, err := db.GetCharacterByID(ctx, characterID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ch.Owner != test.owner {
+				t.Errorf("character owner = %q, want %q", ch.Owner, test.owner)
+			}
+		})
+	}
+}
+
+func TestUserRemovalGuards(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		user   string
+		status int
+		role   string
+	}{
+		{"delete the last admin", http.MethodPost, "/deleteUser", `{"username": "admin"}`, "admin", http.StatusConflict, "admin"},
+		{"delete the last admin on v2", http.MethodDelete, "/api/v2/users/admin", "", "admin", http.StatusConflict, "admin"},
+		{"last admin deletes its account", http.MethodPost, "/deleteAccount", `{"password": "admin-password"}`, "admin", http.StatusConflict, "admin"},
+		{"demote the last admin", http.MethodPost, "/updateUser", `{"userToUpdate": "admin", "user": {"Username": "admin", "UserRole": "dm"}}`, "admin", http.StatusConflict, "admin"},
+		{"demote the last admin on v2", http.MethodPatch, "/api/v2/users/admin", `{"UserRole": "dm"}`, "admin", http.StatusConflict, "admin"},
+		{"replace the last admin on v2", http.MethodPut, "/api/v2/users/admin", `{"Username": "admin", "UserRole": "player"}`, "admin", http.StatusConflict, "admin"},
+		{"delete a DM with campaigns", http.MethodPost, "/deleteUser", `{"username": "dm"}`, "dm", http.StatusConflict, "dm"},
+		{"delete a DM with campaigns on v2", http.MethodDelete, "/api/v2/users/dm", "", "dm", http.StatusConflict, "dm"},
+		{"demote a DM with campaigns", http.MethodPatch, "/api/v2/users/dm", `{"UserRole": "player"}`, "dm", http.StatusConflict, "dm"},
+		{"promote a DM with campaigns", http.MethodPatch, "/api/v2/users/dm", `{"UserRole": "admin"}`, "dm", http.StatusOK, "admin"},
+		{"delete a player", http.MethodDelete, "/api/v2/users/p", "", "p", http.StatusNoContent, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestStore(t)
+			tokens := addTestUsers(t, map[string]string{
+				"admin": dndinterface.RoleAdmin,
+				"dm":    dndinterface.RoleDM,
+				"p":     dndinterface.RolePlayer,
+			})
+			if _, err := db.AddCampain(ctx, dndinterface.Campaign{Name: "c", DM: "dm", Players: []string{"p"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			w := serve(newRouter(), test.method, test.path, tokens["admin"], test.body)
+			if w.Code != test.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+
+			user, err := db.GetUser(ctx, test.user)
+			if test.role == "" {
+				if err != dndinterface.ErrNotFound {
+					t.Errorf("GetUser error = %v, want ErrNotFound", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.UserRole != test.role {
+				t.Errorf("role = %q, want %q", user.UserRole, test.role)
+			}
+		})
+	}
+}
+
+func TestPatchOwnRoleAfterDemotion(t *testing.T) {
+	ctx := context.Background()
+	useTestStore(t)
+	tokens := addTestUsers(t, map[string]string{
+		"admin": dndinterface.RoleAdmin,
+		"dm":    dndinterface.RoleDM,
+	})
+	router := newRouter()
+
+	w := serve(router, http.MethodPatch, "/api/v2/users/dm", tokens["admin"], `{"UserRole": "player"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("demote status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	// The access token of the DM still carries the old role
+	w = serve(router, http.MethodPatch, "/api/v2/users/dm", tokens["dm"], `{"UserRole": "dm"}`)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusForbidden, w.Body)
+	}
+	user, err := db.GetUser(ctx, "dm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.UserRole != dndinterface.RolePlayer {
+		t.Errorf("role = %q, want %q", user.UserRole, dndinterface.RolePlayer)
+	}
+}
+
+func TestDeleteAccountCharacters(t *testing.T) {
+	ctx := context.Background()
+	useTestStore(t)
+	tokens := addTestUsers(t, map[string]string{
+		"dm": dndinterface.RoleDM,
+		"a":  dndinterface.RolePlayer,
+		"b":  dndinterface.RolePlayer,
+	})
+	campaignID, err := db.AddCampain(ctx, dndinterface.Campaign{Name: "c", DM: "dm", Players: []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	characterID, err := db.AddCharacter(ctx, campaignID, dndinterface.Character{CharacterName: "Vex", Owner: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter()
+
+	if w := serve(router, http.MethodPost, "/deleteAccount", tokens["a"], `{"password": "a-password"}`); w.Code != http.StatusOK {
+		t.Fatalf("delete account status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	// The character goes to the DM, other players still can not touch it
+	path := "/api/v2/characters/" + characterID
+	if w := serve(router, http.MethodDelete, path, tokens["b"], ""); w.Code != http.StatusForbidden {
+		t.Errorf("DELETE status = %d, want %d: %s", w.Code, http.StatusForbidden, w.Body)
+	}
+	ch, err := db.GetCharacterByID(ctx, characterID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.Owner != "dm" {
+		t.Errorf("owner = %q, want dm", ch.Owner)
+	}
+}
+
+func TestAddUser(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"player", `{"Username": "p", "Password": "password", "UserRole": "player"}`, http.StatusOK},
+		{"default role", `{"Username": "p", "Password": "password"}`, http.StatusOK},
+		{"empty username", `{"Username": "", "Password": "password", "UserRole": "player"}`, http.StatusUnprocessableEntity},
+		{"unknown role", `{"Username": "p", "Password": "password", "UserRole": "king"}`, http.StatusUnprocessableEntity},
+		{"bad email", `{"Username": "p", "Password": "password", "email": "nope"}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestStore(t)
+			tokens := addTestUsers(t, map[string]string{"admin": dndinterface.RoleAdmin})
+
+			w := serve(newRouter(), http.MethodPost, "/addUser", tokens["admin"], test.body)
+			if w.Code != test.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+
+			users, err := db.GetAllUsers(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := 1
+			if test.status == http.StatusOK {
+				want = 2
+			}
+			if len(users) != want {
+				t.Errorf("%d users, want %d: %v", len(users), want, users)
+			}
+		})
+	}
+}
+
+func TestCreateCampaignIgnoresCharacters(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"v1", "/addCampaign", http.StatusOK},
+		{"v2", "/api/v2/campaigns", http.StatusCreated},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestStore(t)
+			tokens := addTestUsers(t, map[string]string{
+				"dm":    dndinterface.RoleDM,
+				"other": dndinterface.RoleDM,
+				"p":     dndinterface.RolePlayer,
+			})
+			campaignID, err := db.AddCampain(ctx, dndinterface.Campaign{Name: "c", DM: "dm", Players: []string{"p"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			characterID, err := db.AddCharacter(ctx, campaignID, dndinterface.Character{CharacterName: "Vex", Owner: "p"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			router := newRouter()
+
+			// Another DM lists the character in a new campaign and deletes it
+			body := `{"Name": "x", "Characters": ["` + characterID + `"]}`
+			w := serve(router, http.MethodPost, test.path, tokens["other"], body)
+			if w.Code != test.status {
+				t.Fatalf("create status = %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			var created dndinterface.Campaign
+			if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+				t.Fatal(err)
+			}
+			if len(created.Characters) != 0 {
+				t.Errorf("created campaign characters = %v, want none", created.Characters)
+			}
+			if w := serve(router, http.MethodDelete, "/api/v2/campaigns/"+created.ID, tokens["other"], ""); w.Code != http.StatusNoContent {
+				t.Fatalf("delete status = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+			}
+
+			if _, err := db.GetCharacterByID(ctx, characterID); err != nil {
+				t.Errorf("GetCharacterByID() error = %v, want the character to survive", err)
+			}
+			campaign, err := db.GetCharacterCampaign(ctx, characterID)
+			if err != nil || campaign.ID != campaignID {
+				t.Errorf("GetCharacterCampaign() = %q, %v, want %q", campaign.ID, err, campaignID)
+			}
+		})
+	}
+}
+
+func TestUpdateCharacterOwner(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		user   string
+		method string
+		path   string
+		body   string
+		status int
+		owner  string
+	}{
+		{"DM on v1 without owner", "dm", http.MethodPost, "/updateCharacter", `{"id": "{id}", "character": {"characterName": "Vex", "level": 2, {stats}}}`, http.StatusOK, "p"},
+		{"DM on v2 without owner", "dm", http.MethodPut, "/api/v2/characters/{id}", `{"characterName": "Vex", "level": 2, {stats}}`, http.StatusNoContent, "p"},
+		{"DM hands over", "dm", http.MethodPut, "/api/v2/characters/{id}", `{"characterName": "Vex", "level": 2, {stats}, "owner": "q"}`, http.StatusNoContent, "q"},
+		{"player can not hand over", "p", http.MethodPut, "/api/v2/characters/{id}", `{"characterName": "Vex", "level": 2, {stats}, "owner": "q"}`, http.StatusNoContent, "p"},
+	}
+	stats := `"stats": {"strength": 10, "dexterity": 10, "constitution": 10, "intelligence": 10, "wisdom": 10, "charisma": 10}`
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestStore(t)
+			tokens := addTestUsers(t, map[string]string{
+				"dm": dndinterface.RoleDM,
+				"p":  dndinterface.RolePlayer,
+				"q":  dndinterface.RolePlayer,
+			})
+			campaignID, err := db.AddCampain(ctx, dndinterface.Campaign{Name: "c", DM: "dm", Players: []string{"p", "q"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ch := dndinterface.Character{CharacterName: "Vex", Level: 1, Owner: "p"}
+			ch.Stats = dndinterface.Stats{Strength: 10, Dexterity: 10, Constitution: 10, Intelligence: 10, Wisdom: 10, Charisma: 10}
+			characterID, err := db.AddCharacter(ctx, campaignID, ch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			router := newRouter()
+
+			replacer := strings.NewReplacer("{id}", characterID, "{stats}", stats)
+			w := serve(router, test.method, replacer.Replace(test.path), tokens[test.user], replacer.Replace(test.body))
+			if w.Code != test.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			stored, err := db.GetCharacterByID(ctx, characterID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Owner != test.owner {
+				t.Fatalf("owner = %q, want %q", stored.Owner, test.owner)
+			}
+
+			// The owner can still save the character afterwards
+			body := replacer.Replace(`{"id": "{id}", "character": {"characterName": "Vex", "level": 3, {stats}}}`)
+			if w := serve(router, http.MethodPost, "/updateCharacter", tokens[test.owner], body); w.Code != http.StatusOK {
+				t.Errorf("owner update status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+		})
+	}
+}
+
+func TestLastAdminGuardIgnoresCase(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"delete its account", http.MethodPost, "/deleteAccount", `{"password": "admin-password"}`},
+		{"delete on v2", http.MethodDelete, "/api/v2/users/admin", ""},
+		{"demote on v2", http.MethodPatch, "/api/v2/users/admin", `{"UserRole": "player"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestStore(t)
+			// Roles were stored as they were sent before they were lower cased
+			tokens := addTestUsers(t, map[string]string{"admin": "Admin"})
+
+			w := serve(newRouter(), test.method, test.path, tokens["admin"], test.body)
+			if w.Code != http.StatusConflict {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+			}
+			user, err := db.GetUser(ctx, "admin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.EqualFold(user.UserRole, dndinterface.RoleAdmin) {
+				t.Errorf("role = %q, want admin", user.UserRole)
+			}
+		})
+	}
+}
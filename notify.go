@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+)
+
+// Notifier delivers messages to users, such as password reset links
+type Notifier interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// newNotifier creates the notifier picked in the config
+func newNotifier(cfg Config) (Notifier, error) {
+	switch cfg.Notifier {
+	case "log":
+		return logNotifier{}, nil
+	case "smtp":
+		return smtpNotifier{cfg: cfg.SMTP}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier %q", cfg.Notifier)
+}
+
+// logNotifier prints messages to the console, for running the server locally
+type logNotifier struct{}
+
+func (logNotifier) Send(ctx context.Context, to, subject, body string) error {
+	fmt.Printf("Message to %s: %s\n%s\n", to, subject, body)
+	return nil
+}
+
+// smtpNotifier sends messages as email
+type smtpNotifier struct {
+	cfg SMTPConfig
+}
+
+func (n smtpNotifier) Send(ctx context.Context, to, subject, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("invalid mail header")
+	}
+
+	addr := net.JoinHostPort(n.cfg.Host, strconv.FormatUint(n.cfg.Port, 10))
+	var auth smtp.Auth
+	if n.cfg.Username != "" {
+		auth = smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, n.cfg.Host)
+	}
+
+	msg := "From: " + n.cfg.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + body + "\r\n"
+	return smtp.SendMail(addr, auth, n.cfg.From, []string{to}, []byte(msg))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+)
+
+type forgotPasswordPost struct {
+	Username string `json:"username"`
+}
+
+// forgotPassword sends a password reset token to the email of the user. The
+// answer is the same whether the user exists or not, and the message is sent
+// in the background so the time taken does not tell either. Requests are
+// throttled per username and IP so nobody can be flooded with messages.
+func forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var postData forgotPasswordPost
+	err := json.NewDecoder(r.Body).Decode(&postData)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	if wait := resetRequests.hit(postData.Username, clientIP(r), time.Now()); wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	go sendPasswordReset(postData.Username)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sendPasswordReset creates a reset token for a user with an email address
+func sendPasswordReset(username string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	user, err := db.GetUser(ctx, username)
+	if err != nil || user.Email == "" {
+		return
+	}
+
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		fmt.Println("Could not create password reset token:", err)
+		return
+	}
+	err = db.AddPasswordReset(ctx, dndinterface.PasswordReset{
+		Hash:      hash,
+		Username:  user.Username,
+		ExpiresAt: time.Now().Add(time.Duration(conf.PasswordResetTTL)),
+	})
+	if err != nil {
+		fmt.Println("Could not store password reset token:", err)
+		return
+	}
+
+	body := "Use this code to choose a new password: " + token
+	if conf.PasswordResetURL != "" {
+		body = "Open this link to choose a new password: " + conf.PasswordResetURL + url.QueryEscape(token)
+	}
+	body += "\n\nThe code expires in " + time.Duration(conf.PasswordResetTTL).String() +
+		". If you did not ask for it you can ignore this message."
+
+	if err := notifier.Send(ctx, user.Email, "Reset your password", body); err != nil {
+		fmt.Println("Could not send password reset:", err)
+	}
+}
+
+type resetPasswordPost struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+// resetPassword sets a new password with a token from forgotPassword. Every
+// session of the user is signed out, the other reset tokens of the user stop
+// working and any login lockout is lifted.
+func resetPassword(w http.ResponseWriter, r *http.Request) {
+	var postData resetPasswordPost
+	err := json.NewDecoder(r.Body).Decode(&postData)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	reset, err := db.UsePasswordReset(r.Context(), hashToken(postData.Token))
+	if err == dndinterface.ErrNotFound {
+		writeError(w, http.StatusForbidden, codeForbidden, "The reset token is not valid or has expired")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	err = db.SetPassword(r.Context(), reset.Username, postData.NewPassword)
+	if err != nil {
+		// Give the token back so a weak password can be corrected
+		if restoreErr := db.AddPasswordReset(r.Context(), reset); restoreErr != nil {
+			writeStoreError(w, restoreErr)
+			return
+		}
+		writeStoreError(w, err)
+		return
+	}
+
+	if err := db.RevokeUserPasswordResets(r.Context(), reset.Username); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if err := db.RevokeUserRefreshTokens(r.Context(), reset.Username); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	logins.succeed(reset.Username)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+)
+
+// message is a message kept by memoryNotifier
+type message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// memoryNotifier keeps every message instead of sending it
+type memoryNotifier struct {
+	mu       sync.Mutex
+	messages []message
+}
+
+func (n *memoryNotifier) Send(ctx context.Context, to, subject, body string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.messages = append(n.messages, message{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// Messages returns a copy of the messages sent so far
+func (n *memoryNotifier) Messages() []message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]message{}, n.messages...)
+}
+
+// useTestStore runs the handlers on an empty memory store and keeps the
+// messages they send
+func useTestStore(t *testing.T) *memoryNotifier {
+	t.Helper()
+	useTestConfig(t)
+	db = dndinterface.NewMemoryDB()
+	logins = newLoginLimiter(conf.Login)
+	resetRequests = newLoginLimiter(conf.Login)
+	sent := &memoryNotifier{}
+	notifier = sent
+	return sent
+}
+
+func post(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	return w
+}
+
+// resetCode takes the reset token out of a password reset message
+func resetCode(t *testing.T, msg message) string {
+	t.Helper()
+	const prefix = "Use this code to choose a new password: "
+	if !strings.HasPrefix(msg.Body, prefix) {
+		t.Fatalf("message body %q has no reset code", msg.Body)
+	}
+	return strings.Fields(strings.TrimPrefix(msg.Body, prefix))[0]
+}
+
+func TestPasswordReset(t *testing.T) {
+	sent := useTestStore(t)
+	ctx := context.Background()
+	if err := db.AddUser(ctx, "p", "old-password", dndinterface.RolePlayer); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetEmail(ctx, "p", "p@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := post(forgotPassword, `{"username": "p"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("forgotPassword status = %d, want %d", w.Code, http.StatusAccepted)
+	}
+	if w := post(forgotPassword, `{"username": "p"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second forgotPassword status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	// The first message is sent in the background
+	deadline := time.Now().Add(time.Second)
+	for len(sent.Messages()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sendPasswordReset("p")
+	messages := sent.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(messages))
+	}
+	if messages[0].To != "p@example.com" {
+		t.Errorf("message sent to %q, want %q", messages[0].To, "p@example.com")
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"unknown token", `{"token": "nope", "newPassword": "new-password"}`, http.StatusForbidden},
+		{"weak password", `{"token": "` + resetCode(t, messages[0]) + `", "newPassword": "x"}`, http.StatusUnprocessableEntity},
+		{"reset", `{"token": "` + resetCode(t, messages[0]) + `", "newPassword": "new-password"}`, http.StatusOK},
+		{"token used twice", `{"token": "` + resetCode(t, messages[0]) + `", "newPassword": "other-password"}`, http.StatusForbidden},
+		{"other token of the user", `{"token": "` + resetCode(t, messages[1]) + `", "newPassword": "other-password"}`, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if w := post(resetPassword, test.body); w.Code != test.status {
+				t.Errorf("resetPassword status = %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+		})
+	}
+
+	if _, err := db.CheckUser(ctx, "p", "new-password"); err != nil {
+		t.Errorf("CheckUser() with the new password error = %v", err)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+	"github.com/dgrijalva/jwt-go"
+)
+
+const refreshCookieName = "refresh_token"
+
+// refreshCookiePaths are the routes that read the refresh token cookie. The
+// cookie is set once for each of them so it is not sent with other requests.
+var refreshCookiePaths = []string{"/refresh", "/signout"}
+
+// keyring holds the keys access tokens are verified with
+type keyring struct {
+	keys    map[string][]byte
+	current string
+}
+
+func newKeyring(keys []SigningKey) keyring {
+	ring := keyring{keys: make(map[string][]byte, len(keys))}
+	for _, key := range keys {
+		ring.keys[key.ID] = []byte(key.Secret)
+		ring.current = key.ID
+	}
+	return ring
+}
+
+// sign signs the claims with the newest key and names it in the kid header
+func (k keyring) sign(method jwt.SigningMethod, claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = k.current
+	return token.SignedString(k.keys[k.current])
+}
+
+// lookup returns the key named in the kid header of a token. Tokens from
+// before key IDs were added are checked against the default key.
+func (k keyring) lookup(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = defaultKeyID
+	}
+	key, found := k.keys[kid]
+	if !found {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// Valid checks the time based claims with the configured leeway for clock
+// skew and requires the issuer and audience of this server
+func (c *Claims) Valid() error {
+	now := time.Now().Unix()
+	leeway := int64(time.Duration(conf.JWTLeeway) / time.Second)
+
+	switch {
+	case !c.VerifyExpiresAt(now-leeway, true):
+		return jwt.NewValidationError("token is expired", jwt.ValidationErrorExpired)
+	case !c.VerifyIssuedAt(now+leeway, false):
+		return jwt.NewValidationError("token used before issued", jwt.ValidationErrorIssuedAt)
+	case !c.VerifyNotBefore(now+leeway, false):
+		return jwt.NewValidationError("token is not valid yet", jwt.ValidationErrorNotValidYet)
+	case !c.VerifyIssuer(conf.JWTIssuer, true):
+		return jwt.NewValidationError("token has the wrong issuer", jwt.ValidationErrorIssuer)
+	case !c.VerifyAudience(conf.JWTAudience, true):
+		return jwt.NewValidationError("token has the wrong audience", jwt.ValidationErrorAudience)
+	}
+	return nil
+}
+
+// parseToken verifies the signature and claims of an access token. Only the
+// configured algorithm is accepted.
+func parseToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	parser := &jwt.Parser{ValidMethods: []string{conf.JWTAlgorithm}}
+
+	_, err := parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != conf.JWTAlgorithm {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return jwtKeys.lookup(token)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// tokenCookie creates a cookie with the configured attributes
+func tokenCookie(name, value string, expires time.Time) *http.Cookie {
+	sameSite, _ := conf.Cookie.sameSiteMode()
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Expires:  expires,
+		Path:     conf.Cookie.Path,
+		Domain:   conf.Cookie.Domain,
+		Secure:   conf.Cookie.Secure,
+		HttpOnly: true,
+		SameSite: sameSite,
+	}
+}
+
+// refreshCookies creates the refresh token cookie for each route that reads it
+func refreshCookies(value string, expires time.Time) []*http.Cookie {
+	var cookies []*http.Cookie
+	for _, route := range refreshCookiePaths {
+		cookie := tokenCookie(refreshCookieName, value, expires)
+		cookie.Path = path.Join(conf.Cookie.Path, route)
+		cookies = append(cookies, cookie)
+	}
+	return cookies
+}
+
+// newRefreshToken returns a random token for the client and the hash of it
+// that is kept in the database
+func newRefreshToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokenResponse is returned in the body to clients that can not keep cookies
+type tokenResponse struct {
+	Token        string    `json:"token"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	RefreshToken string    `json:"refreshToken"`
+}
+
+// issueTokens signs a short lived access token and creates a new refresh
+// token for the user, both are handed to the client as cookies
+func issueTokens(w http.ResponseWriter, r *http.Request, username, userRole string) (tokenResponse, error) {
+	now := time.Now()
+	accessExpires := now.Add(time.Duration(conf.AccessTokenTTL))
+
+	claims := &Claims{
+		Username: username,
+		Type:     userRole,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    conf.JWTIssuer,
+			Audience:  conf.JWTAudience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: accessExpires.Unix(),
+		},
+	}
+
+	tokenString, err := jwtKeys.sign(jwt.GetSigningMethod(conf.JWTAlgorithm), claims)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	refresh, hash, err := newRefreshToken()
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	refreshExpires := now.Add(time.Duration(conf.RefreshTokenTTL))
+
+	err = db.AddRefreshToken(r.Context(), dndinterface.RefreshToken{
+		Hash:      hash,
+		Username:  username,
+		ExpiresAt: refreshExpires,
+	})
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	http.SetCookie(w, tokenCookie("token", tokenString, accessExpires))
+	for _, cookie := range refreshCookies(refresh, refreshExpires) {
+		http.SetCookie(w, cookie)
+	}
+	return tokenResponse{Token: tokenString, ExpiresAt: accessExpires, RefreshToken: refresh}, nil
+}
+
+// requestToken returns the access token from the Authorization header or,
+// when there is none, from the token cookie
+func requestToken(r *http.Request) (string, bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		parts := strings.SplitN(header, " ", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+			return "", false
+		}
+		return strings.TrimSpace(parts[1]), true
+	}
+
+	c, err := r.Cookie("token")
+	if err != nil {
+		return "", false
+	}
+	return c.Value, true
+}
+
+type refreshPost struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// clearTokenCookies removes the token cookies. Refresh tokens set on the
+// cookie path itself by older versions are removed as well.
+func clearTokenCookies(w http.ResponseWriter) {
+	cookies := append(refreshCookies("", time.Unix(0, 0)),
+		tokenCookie("token", "", time.Unix(0, 0)),
+		tokenCookie(refreshCookieName, "", time.Unix(0, 0)))
+	for _, cookie := range cookies {
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
+
+// refresh trades a refresh token for a new access token and a new refresh
+// token. The old refresh token can not be used again.
+func refresh(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	// Clients without cookies send the refresh token in the body and get
+	// the new tokens back the same way
+	var refreshToken string
+	inBody := false
+	if c, err := r.Cookie(refreshCookieName); err == nil {
+		refreshToken = c.Value
+	} else {
+		var postData refreshPost
+		if err := json.NewDecoder(r.Body).Decode(&postData); err != nil || postData.RefreshToken == "" {
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "No refresh token was sent")
+			return
+		}
+		refreshToken = postData.RefreshToken
+		inBody = true
+	}
+
+	token, err := db.UseRefreshToken(r.Context(), hashToken(refreshToken))
+	if err == dndinterface.ErrNotFound {
+		clearTokenCookies(w)
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "The refresh token is not valid or has expired")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	// The role is read again so changes take effect on the next refresh
+	user, err := db.GetUser(r.Context(), token.Username)
+	if err == dndinterface.ErrNotFound {
+		clearTokenCookies(w)
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "The refresh token is not valid or has expired")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	tokens, err := issueTokens(w, r, user.Username, user.UserRole)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if inBody {
+		json.NewEncoder(w).Encode(tokens)
+	}
+}
+
+// signOut revokes the refresh token of the client and removes its cookies
+func signOut(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	refreshToken := ""
+	if c, err := r.Cookie(refreshCookieName); err == nil {
+		refreshToken = c.Value
+	} else {
+		var postData refreshPost
+		if json.NewDecoder(r.Body).Decode(&postData) == nil {
+			refreshToken = postData.RefreshToken
+		}
+	}
+
+	if refreshToken != "" {
+		if err := db.RevokeRefreshToken(r.Context(), hashToken(refreshToken)); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+
+	clearTokenCookies(w)
+}
+
+type signOutEverywherePost struct {
+	Username string `json:"username"`
+}
+
+// signOutEverywhere revokes all refresh tokens of a user. Access tokens
+// that are already handed out stay valid until they expire.
+func signOutEverywhere(w http.ResponseWriter, r *http.Request) {
+	var postData signOutEverywherePost
+	err := json.NewDecoder(r.Body).Decode(&postData)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	if err := db.RevokeUserRefreshTokens(r.Context(), postData.Username); err != nil {
+		writeStoreError(w, err)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// useTestConfig sets up the configuration and keys the token code reads
+func useTestConfig(t *testing.T) {
+	t.Helper()
+	conf = defaultConfig()
+	conf.JWTKey = "test-secret"
+	jwtKeys = newKeyring(conf.signingKeys())
+}
+
+func testClaims(now time.Time) *Claims {
+	return &Claims{
+		Username: "p",
+		Type:     "player",
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    conf.JWTIssuer,
+			Audience:  conf.JWTAudience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(time.Minute).Unix(),
+		},
+	}
+}
+
+func TestParseToken(t *testing.T) {
+	useTestConfig(t)
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		token func() (string, error)
+		valid bool
+	}{
+		{
+			name: "valid",
+			token: func() (string, error) {
+				return jwtKeys.sign(jwt.SigningMethodHS256, testClaims(now))
+			},
+			valid: true,
+		},
+		{
+			name: "other HMAC algorithm",
+			token: func() (string, error) {
+				return jwtKeys.sign(jwt.SigningMethodHS512, testClaims(now))
+			},
+		},
+		{
+			name: "algorithm none",
+			token: func() (string, error) {
+				return jwt.NewWithClaims(jwt.SigningMethodNone, testClaims(now)).
+					SignedString(jwt.UnsafeAllowNoneSignatureType)
+			},
+		},
+		{
+			name: "wrong issuer",
+			token: func() (string, error) {
+				claims := testClaims(now)
+				claims.Issuer = "someone-else"
+				return jwtKeys.sign(jwt.SigningMethodHS256, claims)
+			},
+		},
+		{
+			name: "wrong audience",
+			token: func() (string, error) {
+				claims := testClaims(now)
+				claims.Audience = "someone-else"
+				return jwtKeys.sign(jwt.SigningMethodHS256, claims)
+			},
+		},
+		{
+			name: "unknown key",
+			token: func() (string, error) {
+				token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims(now))
+				token.Header["kid"] = "unknown"
+				return token.SignedString([]byte("test-secret"))
+			},
+		},
+		{
+			name: "expired within leeway",
+			token: func() (string, error) {
+				claims := testClaims(now)
+				claims.ExpiresAt = now.Add(-10 * time.Second).Unix()
+				return jwtKeys.sign(jwt.SigningMethodHS256, claims)
+			},
+			valid: true,
+		},
+		{
+			name: "expired",
+			token: func() (string, error) {
+				claims := testClaims(now)
+				claims.ExpiresAt = now.Add(-time.Minute).Unix()
+				return jwtKeys.sign(jwt.SigningMethodHS256, claims)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokenString, err := test.token()
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := parseToken(tokenString)
+			if test.valid && err != nil {
+				t.Fatalf("parseToken() error = %v, want none", err)
+			}
+			if !test.valid && err == nil {
+				t.Fatalf("parseToken() accepted the token for %q", claims.Username)
+			}
+		})
+	}
+}
+
+// signInForTokens signs the user in and returns the tokens from the body
+func signInForTokens(t *testing.T, router http.Handler, username, password string) tokenResponse {
+	t.Helper()
+	body := `{"username": "` + username + `", "password": "` + password + `", "returnToken": true}`
+	w := serve(router, http.MethodPost, "/signin", "", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("signin status = %d: %s", w.Code, w.Body)
+	}
+	var tokens tokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&tokens); err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+// refreshWith sends a refresh token in the body of /refresh, or in the
+// cookie when cookie is true
+func refreshWith(router http.Handler, token string, cookie bool) *httptest.ResponseRecorder {
+	var r *http.Request
+	if cookie {
+		r = httptest.NewRequest(http.MethodPost, "/refresh", nil)
+		r.AddCookie(&http.Cookie{Name: refreshCookieName, Value: token})
+	} else {
+		r = httptest.NewRequest(http.MethodPost, "/refresh", strings.NewReader(`{"refreshToken": "`+token+`"}`))
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+// responseRefreshToken returns the refresh token a response handed out
+func responseRefreshToken(t *testing.T, w *httptest.ResponseRecorder, cookie bool) string {
+	t.Helper()
+	if !cookie {
+		var tokens tokenResponse
+		if err := json.NewDecoder(w.Body).Decode(&tokens); err != nil {
+			t.Fatal(err)
+		}
+		return tokens.RefreshToken
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == refreshCookieName && c.Value != "" {
+			return c.Value
+		}
+	}
+	t.Fatal("no refresh token cookie was set")
+	return ""
+}
+
+func TestRefreshRotation(t *testing.T) {
+	for _, cookie := range []bool{false, true} {
+		name := "body"
+		if cookie {
+			name = "cookie"
+		}
+		t.Run(name, func(t *testing.T) {
+			useTestStore(t)
+			addTestUsers(t, map[string]string{"p": dndinterface.RolePlayer})
+			router := newRouter()
+			first := signInForTokens(t, router, "p", "p-password").RefreshToken
+
+			w := refreshWith(router, first, cookie)
+			if w.Code != http.StatusOK {
+				t.Fatalf("first refresh status = %d: %s", w.Code, w.Body)
+			}
+			second := responseRefreshToken(t, w, cookie)
+			if second == "" || second == first {
+				t.Fatalf("refresh handed out %q, want a new refresh token", second)
+			}
+
+			// The used token is gone, the one it was traded for still works
+			if w := refreshWith(router, first, cookie); w.Code != http.StatusUnauthorized {
+				t.Errorf("reused refresh status = %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body)
+			}
+			if w := refreshWith(router, second, cookie); w.Code != http.StatusOK {
+				t.Errorf("refresh with the new token status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+		})
+	}
+}
+
+func TestSignOutRevokesRefreshToken(t *testing.T) {
+	useTestStore(t)
+	addTestUsers(t, map[string]string{"p": dndinterface.RolePlayer})
+	router := newRouter()
+	signedOut := signInForTokens(t, router, "p", "p-password").RefreshToken
+	other := signInForTokens(t, router, "p", "p-password").RefreshToken
+
+	w := serve(router, http.MethodPost, "/signout", "", `{"refreshToken": "`+signedOut+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("signout status = %d: %s", w.Code, w.Body)
+	}
+	if w := refreshWith(router, signedOut, false); w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after signout status = %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body)
+	}
+
+	// Other sessions of the user stay signed in
+	if w := refreshWith(router, other, false); w.Code != http.StatusOK {
+		t.Errorf("refresh of another session status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+}
+
+func TestSignOutEverywhere(t *testing.T) {
+	useTestStore(t)
+	tokens := addTestUsers(t, map[string]string{
+		"admin": dndinterface.RoleAdmin,
+		"p":     dndinterface.RolePlayer,
+	})
+	router := newRouter()
+	first := signInForTokens(t, router, "p", "p-password").RefreshToken
+	second := signInForTokens(t, router, "p", "p-password").RefreshToken
+
+	w := serve(router, http.MethodPost, "/signoutEverywhere", tokens["admin"], `{"username": "p"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("signoutEverywhere status = %d: %s", w.Code, w.Body)
+	}
+	for _, token := range []string{first, second} {
+		if w := refreshWith(router, token, false); w.Code != http.StatusUnauthorized {
+			t.Errorf("refresh status = %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	useTestConfig(t)
+	now := time.Now()
+	old := SigningKey{ID: "2026-01", Secret: "old-secret"}
+	current := SigningKey{ID: "2026-06", Secret: "new-secret"}
+
+	jwtKeys = newKeyring([]SigningKey{old})
+	oldToken, err := jwtKeys.sign(jwt.SigningMethodHS256, testClaims(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new key signs new tokens, tokens of the old key still verify
+	jwtKeys = newKeyring([]SigningKey{old, current})
+	newToken, err := jwtKeys.sign(jwt.SigningMethodHS256, testClaims(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := new(jwt.Parser).ParseUnverified(newToken, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := token.Header["kid"]; kid != current.ID {
+		t.Errorf("kid of a new token = %v, want %q", kid, current.ID)
+	}
+	for name, tokenString := range map[string]string{"old": oldToken, "new": newToken} {
+		if _, err := parseToken(tokenString); err != nil {
+			t.Errorf("parseToken() of the %s token error = %v, want none", name, err)
+		}
+	}
+
+	// Once the old key is dropped its tokens are turned down
+	jwtKeys = newKeyring([]SigningKey{current})
+	if _, err := parseToken(oldToken); err == nil {
+		t.Error("parseToken() accepted a token of a removed key")
+	}
+	if _, err := parseToken(newToken); err != nil {
+		t.Errorf("parseToken() of the new token error = %v, want none", err)
+	}
+
+	// A token naming a key that is not known is turned down even when its
+	// signature matches one of the keys
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims(now))
+	forged.Header["kid"] = "2025-12"
+	forgedString, err := forged.SignedString([]byte(current.Secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseToken(forgedString); err == nil {
+		t.Error("parseToken() accepted a token with an unknown kid")
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+)
+
+// The limits of the D&D 5e rules that characters are checked against
+const (
+	minAbilityScore = 1
+	maxAbilityScore = 30
+	minLevel        = 1
+	maxLevel        = 20
+)
+
+// validator collects the field errors of a request body. Fields are named
+// by their JSON path, prefix is put in front of every name for bodies that
+// wrap the resource.
+type validator struct {
+	prefix string
+	fields []fieldError
+}
+
+func (v *validator) fail(field, message string) {
+	v.fields = append(v.fields, fieldError{Field: v.prefix + field, Message: message})
+}
+
+func (v *validator) notEmpty(field, value string) {
+	if value == "" {
+		v.fail(field, "Must not be empty")
+	}
+}
+
+func (v *validator) inRange(field string, value, min, max int) {
+	if value < min || value > max {
+		v.fail(field, "Must be between "+strconv.Itoa(min)+" and "+strconv.Itoa(max))
+	}
+}
+
+func (v *validator) notNegative(field string, value int) {
+	if value < 0 {
+		v.fail(field, "Must not be negative")
+	}
+}
+
+// knownUser checks that a user with the name exists
+func (v *validator) knownUser(ctx context.Context, field, username string) error {
+	_, err := db.GetUser(ctx, username)
+	if err == dndinterface.ErrNotFound {
+		v.fail(field, "No user named "+strconv.Quote(username))
+		return nil
+	}
+	return err
+}
+
+// characterRuleFields are the JSON paths of the fields characterFieldErrors
+// looks at, a patch is only stored if they did not change since it was checked
+var characterRuleFields = []string{
+	"characterName", "level", "exp", "expPoints",
+	"stats.strength", "stats.dexterity", "stats.constitution",
+	"stats.intelligence", "stats.wisdom", "stats.charisma",
+	"hp.maxHP", "hp.currHP", "hp.tempHP", "owner",
+}
+
+// characterFieldErrors checks a character against the rules of the game
+func characterFieldErrors(ctx context.Context, prefix string, ch dndinterface.Character) ([]fieldError, error) {
+	v := validator{prefix: prefix}
+	v.notEmpty("characterName", ch.CharacterName)
+	v.inRange("level", ch.Level, minLevel, maxLevel)
+	v.notNegative("exp", ch.Exp)
+	v.notNegative("expPoints", ch.ExpPoints)
+
+	abilities := []struct {
+		field string
+		score int
+	}{
+		{"stats.strength", ch.Stats.Strength},
+		{"stats.dexterity", ch.Stats.Dexterity},
+		{"stats.constitution", ch.Stats.Constitution},
+		{"stats.intelligence", ch.Stats.Intelligence},
+		{"stats.wisdom", ch.Stats.Wisdom},
+		{"stats.charisma", ch.Stats.Charisma},
+	}
+	for _, ability := range abilities {
+		v.inRange(ability.field, ability.score, minAbilityScore, maxAbilityScore)
+	}
+
+	v.notNegative("hp.maxHP", ch.Hp.MaxHP)
+	v.notNegative("hp.tempHP", ch.Hp.TempHP)
+	if ch.Hp.CurrHP > ch.Hp.MaxHP {
+		v.fail("hp.currHP", "Must not be more than hp.maxHP")
+	}
+
+	if ch.Owner != "" {
+		if err := v.knownUser(ctx, "owner", ch.Owner); err != nil {
+			return nil, err
+		}
+	}
+	return v.fields, nil
+}
+
+// campaignFieldErrors checks that a campaign has a name and that its DM and
+// players are users
+func campaignFieldErrors(ctx context.Context, prefix string, campaign dndinterface.Campaign) ([]fieldError, error) {
+	v := validator{prefix: prefix}
+	v.notEmpty("Name", campaign.Name)
+
+	if campaign.DM == "" {
+		v.fail("DM", "Must not be empty")
+	} else if err := v.knownUser(ctx, "DM", campaign.DM); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for i, player := range campaign.Players {
+		field := "Players." + strconv.Itoa(i)
+		if seen[player] {
+			v.fail(field, "Is already a player")
+			continue
+		}
+		seen[player] = true
+		if err := v.knownUser(ctx, field, player); err != nil {
+			return nil, err
+		}
+	}
+	return v.fields, nil
+}
+
+// userFieldErrors checks the fields of a user that is created or updated
+func userFieldErrors(prefix string, user dndinterface.User) []fieldError {
+	v := validator{prefix: prefix}
+	v.notEmpty("Username", user.Username)
+	if !dndinterface.ValidRole(user.UserRole) {
+		v.fail("UserRole", "Must be admin, dm or player")
+	}
+	if user.Email != "" && !validEmail(user.Email) {
+		v.fail("email", "Not a valid email address")
+	}
+	return v.fields
+}
+
+// checkCharacter writes the error response unless the character is valid
+func checkCharacter(w http.ResponseWriter, r *http.Request, prefix string, ch dndinterface.Character) bool {
+	fields, err := characterFieldErrors(r.Context(), prefix, ch)
+	return checkFields(w, fields, err)
+}
+
+// checkCampaign writes the error response unless the campaign is valid
+func checkCampaign(w http.ResponseWriter, r *http.Request, prefix string, campaign dndinterface.Campaign) bool {
+	fields, err := campaignFieldErrors(r.Context(), prefix, campaign)
+	return checkFields(w, fields, err)
+}
+
+func checkFields(w http.ResponseWriter, fields []fieldError, err error) bool {
+	if err != nil {
+		writeStoreError(w, err)
+		return false
+	}
+	if len(fields) > 0 {
+		writeInvalid(w, fields...)
+		return false
+	}
+	return true
+}
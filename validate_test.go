@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	dndinterface "github.com/Typelias/DnDBackend/DBInterface"
+)
+
+// validCharacter returns a character that breaks none of the rules
+func validCharacter() dndinterface.Character {
+	ch := dndinterface.Character{CharacterName: "Vex", Level: 1, Owner: "p"}
+	ch.Stats = dndinterface.Stats{Strength: 10, Dexterity: 10, Constitution: 10, Intelligence: 10, Wisdom: 10, Charisma: 10}
+	ch.Hp.MaxHP = 10
+	ch.Hp.CurrHP = 10
+	return ch
+}
+
+// fieldNames returns the names of the fields that failed
+func fieldNames(fields []fieldError) []string {
+	var names []string
+	for _, field := range fields {
+		names = append(names, field.Field)
+	}
+	return names
+}
+
+func TestCharacterFieldErrors(t *testing.T) {
+	useTestStore(t)
+	addTestUsers(t, map[string]string{"p": dndinterface.RolePlayer})
+
+	tests := []struct {
+		name   string
+		prefix string
+		change func(*dndinterface.Character)
+		fields []string
+	}{
+		{"valid", "", func(*dndinterface.Character) {}, nil},
+		{"no name", "", func(ch *dndinterface.Character) { ch.CharacterName = "" }, []string{"characterName"}},
+		{"level 0", "", func(ch *dndinterface.Character) { ch.Level = 0 }, []string{"level"}},
+		{"level 1", "", func(ch *dndinterface.Character) { ch.Level = 1 }, nil},
+		{"level 20", "", func(ch *dndinterface.Character) { ch.Level = 20 }, nil},
+		{"level 21", "", func(ch *dndinterface.Character) { ch.Level = 21 }, []string{"level"}},
+		{"negative exp", "", func(ch *dndinterface.Character) { ch.Exp = -1; ch.ExpPoints = -1 }, []string{"exp", "expPoints"}},
+		{"ability score 0", "", func(ch *dndinterface.Character) { ch.Stats.Strength = 0 }, []string{"stats.strength"}},
+		{"ability score 1", "", func(ch *dndinterface.Character) { ch.Stats.Dexterity = 1 }, nil},
+		{"ability score 30", "", func(ch *dndinterface.Character) { ch.Stats.Wisdom = 30 }, nil},
+		{"ability score 31", "", func(ch *dndinterface.Character) { ch.Stats.Charisma = 31 }, []string{"stats.charisma"}},
+		{"every ability score", "", func(ch *dndinterface.Character) { ch.Stats = dndinterface.Stats{} }, []string{
+			"stats.strength", "stats.dexterity", "stats.constitution",
+			"stats.intelligence", "stats.wisdom", "stats.charisma",
+		}},
+		{"currHP equals maxHP", "", func(ch *dndinterface.Character) { ch.Hp.CurrHP = ch.Hp.MaxHP }, nil},
+		{"currHP above maxHP", "", func(ch *dndinterface.Character) { ch.Hp.CurrHP = ch.Hp.MaxHP + 1 }, []string{"hp.currHP"}},
+		{"negative HP", "", func(ch *dndinterface.Character) { ch.Hp.MaxHP = -1; ch.Hp.CurrHP = -1; ch.Hp.TempHP = -1 }, []string{"hp.maxHP", "hp.tempHP"}},
+		{"unknown owner", "", func(ch *dndinterface.Character) { ch.Owner = "nobody" }, []string{"owner"}},
+		{"no owner", "", func(ch *dndinterface.Character) { ch.Owner = "" }, nil},
+		{"prefix", "character.", func(ch *dndinterface.Character) { ch.Level = 0; ch.Hp.CurrHP = 11 }, []string{"character.level", "character.hp.currHP"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ch := validCharacter()
+			test.change(&ch)
+			fields, err := characterFieldErrors(context.Background(), test.prefix, ch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fieldNames(fields); !reflect.DeepEqual(got, test.fields) {
+				t.Errorf("fields = %v, want %v", got, test.fields)
+			}
+		})
+	}
+}
+
+func TestCampaignFieldErrors(t *testing.T) {
+	useTestStore(t)
+	addTestUsers(t, map[string]string{
+		"dm": dndinterface.RoleDM,
+		"p":  dndinterface.RolePlayer,
+		"q":  dndinterface.RolePlayer,
+	})
+
+	tests := []struct {
+		name     string
+		prefix   string
+		campaign dndinterface.Campaign
+		fields   []string
+	}{
+		{"valid", "", dndinterface.Campaign{Name: "c", DM: "dm", Players: []string{"p", "q"}}, nil},
+		{"no players", "", dndinterface.Campaign{Name: "c", DM: "dm"}, nil},
+		{"no name", "", dndinterface.Campaign{DM: "dm"}, []string{"Name"}},
+		{"no DM", "", dndinterface.Campaign{Name: "c"}, []string{"DM"}},
+		{"unknown DM", "", dndinterface.Campaign{Name: "c", DM: "nobody"}, []string{"DM"}},
+		{"unknown player", "", dndinterface.Campaign{Name: "c", DM: "dm", Players: []string{"p", "nobody"}}, []string{"Players.1"}},
+		{"player twice", "", dndinterface.Campaign{Name: "c", DM: "dm", Players: []string{"p", "q", "p"}}, []string{"Players.2"}},
+		{"prefix", "campaign.", dndinterface.Campaign{Players: []string{"nobody"}}, []string{"campaign.Name", "campaign.DM", "campaign.Players.0"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fields, err := campaignFieldErrors(context.Background(), test.prefix, test.campaign)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fieldNames(fields); !reflect.DeepEqual(got, test.fields) {
+				t.Errorf("fields = %v, want %v", got, test.fields)
+			}
+		})
+	}
+}